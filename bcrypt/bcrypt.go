@@ -0,0 +1,40 @@
+//Package bcrypt hashes passwords with bcrypt
+package bcrypt
+
+import (
+	"github.com/madskrogh/finisafricae"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+//Limits of the cost of Hasher
+const (
+	MinCost = bcrypt.MinCost
+	MaxCost = bcrypt.MaxCost
+)
+
+//Hasher is a finisafricae.PasswordHasher making bcrypt hashes. Every increase of Cost by one doubles the time
+//hashing and comparing takes.
+type Hasher struct {
+	Cost int
+}
+
+//Hash returns the bcrypt hash of password
+func (h *Hasher) Hash(password string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(b), err
+}
+
+//Compare returns nil if password matches hash. Hashes that aren't bcrypt hashes match no password.
+func (h *Hasher) Compare(hash, password string) error {
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return finisafricae.Errorf(finisafricae.ErrUnauthorized, "Wrong password.")
+	}
+	return nil
+}
+
+//NeedsRehash returns true if hash has a lower cost than Hasher, or isn't a bcrypt hash
+func (h *Hasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < h.Cost
+}
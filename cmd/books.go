@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/madskrogh/finisafricae"
+	"github.com/madskrogh/finisafricae/importer"
+	"github.com/madskrogh/finisafricae/marc"
+)
+
+//runBooks runs the books subcommands import and export
+func runBooks(s *services, args []string) error {
+	cmd, args := subcommand(args)
+	fs := flag.NewFlagSet("books "+cmd, flag.ExitOnError)
+	email := fs.String("user", "", "email of the user whose library is imported to or exported from")
+	format := fs.String("format", "", "marcxml, marc, epub or opf. Guessed from the file extension if empty")
+	switch cmd {
+	case "import":
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			return errors.New("books import: no files given")
+		}
+		u, err := userFromEmail(s, *email)
+		if err != nil {
+			return err
+		}
+		var bs []*finisafricae.Book
+		for _, name := range fs.Args() {
+			fbs, err := readFile(name, *format)
+			if err != nil {
+				return fmt.Errorf("reading %s: %v", name, err)
+			}
+			bs = append(bs, fbs...)
+		}
+		skipped, err := importer.Import(s.BookService, u.ID, bs)
+		if err != nil {
+			return err
+		}
+		for _, sk := range skipped {
+			fmt.Printf("skipped book %d %q: %s\n", sk.N, sk.Title, sk.Reason)
+		}
+		fmt.Printf("imported %d books, skipped %d\n", len(bs)-len(skipped), len(skipped))
+		return nil
+
+	case "export":
+		fs.Parse(args)
+		u, err := userFromEmail(s, *email)
+		if err != nil {
+			return err
+		}
+		bs, err := s.BookService.Books(u.ID)
+		if err != nil {
+			return err
+		}
+		name := fs.Arg(0)
+		toFile := name != "" && name != "-"
+		if toFile && *format == "" {
+			*format = importer.Format(name)
+		}
+		//The format is checked before the file is created, so no empty file is left behind
+		var write func(io.Writer, []*finisafricae.Book) error
+		switch *format {
+		case "marc":
+			write = marc.Write
+		case "marcxml", "":
+			write = marc.WriteXML
+		default:
+			return fmt.Errorf("books export: cannot export to %s", *format)
+		}
+		if !toFile {
+			return write(os.Stdout, bs)
+		}
+		f, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		if err := write(f, bs); err != nil {
+			f.Close()
+			return err
+		}
+		//Some file systems only report a failed write when the file is closed
+		return f.Close()
+	}
+	return fmt.Errorf("unknown books command %q", cmd)
+}
+
+//readFile reads the books of the named file in the given format, or the format of its name if none is given
+func readFile(name, format string) ([]*finisafricae.Book, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return importer.Read(f, name, fi.Size(), format)
+}
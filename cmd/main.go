@@ -1,51 +1,178 @@
+//Command finisafricae runs the finis Africae web server and provides administrative commands for users, books and sessions
 package main
 
 import (
 	"database/sql"
-	"net/http"
+	"flag"
+	"fmt"
+	"os"
 
-	handler "github.com/madskrogh/finisafricae/http"
+	"github.com/madskrogh/finisafricae"
+	"github.com/madskrogh/finisafricae/bcrypt"
+	"github.com/madskrogh/finisafricae/config"
+	"github.com/madskrogh/finisafricae/inmem"
 	"github.com/madskrogh/finisafricae/mysql"
-	"github.com/madskrogh/finisafricae/util"
+	"github.com/madskrogh/finisafricae/pwned"
+)
 
-	_ "github.com/go-sql-driver/mysql"
+const usage = `Usage: finisafricae [-config file] [-dsn dsn] <command> [arguments]
 
-	"html/template"
-)
+Commands:
+  serve                                  start the web server
+  migrate                                create and upgrade the database tables
+  user create -email e -uname u          create a user, the password is read from -password or standard input
+  user list                              list all users
+  user delete -email e                   delete a user with their books and sessions
+  user reset-password -email e           set a new password, read from -password or standard input
+  user role -email e -role r             set the role of a user to guest, member or admin
+  user disable -email e                  stop a user from logging in and log them out
+  user enable -email e                   let a disabled user login again
+  books import -user e [-format f] file  import books from MARC 21, MARCXML, EPUB or OPF files
+  books export -user e [-format f] [file]
+                                         export a library as MARCXML or MARC 21
+  sessions purge [-all] [-idle d] [-absolute d]
+                                         delete expired sessions, or all sessions
+
+Run a command with -h for its flags.
+
+Settings are read from the YAML or TOML file given by -config or FINISAFRICAE_CONFIG,
+then from the environment variables below, then from flags.
+
+Environment variables:
+`
 
-var Templates *template.Template
+//services holds the backend the commands operate on. Commands only use the finisafricae interfaces,
+//so a different backend only needs to be wired up here.
+type services struct {
+	db             *sql.DB
+	UserService    finisafricae.UserService
+	BookService    finisafricae.BookService
+	SessionService finisafricae.SessionService
 
-func init() {
-	Templates = template.Must(template.ParseGlob("/path/to/html/templaters"))
+	PersistentTokenService finisafricae.PersistentTokenService
+	OneTimeTokenService    finisafricae.OneTimeTokenService
+	LoginAttemptService    finisafricae.LoginAttemptService
+	APITokenService        finisafricae.APITokenService
+	IdentityService        finisafricae.IdentityService
+	AuditService           finisafricae.AuditService
+	PublicLibraryService   finisafricae.PublicLibraryService
 }
 
 func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		for _, name := range config.EnvNames() {
+			fmt.Fprintln(os.Stderr, "  "+name)
+		}
+	}
+	path := flag.String("config", os.Getenv("FINISAFRICAE_CONFIG"), "YAML or TOML config file")
+	dsn := flag.String("dsn", "", "MySQL data source name")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "finisafricae:", err)
+		os.Exit(1)
+	}
+	if *dsn != "" {
+		cfg.Database.DSN = *dsn
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "finisafricae:", err)
+		os.Exit(1)
+	}
 
 	//Start mysql db
-	db, err := sql.Open("mysql", "user:password@/database")
-	util.HandleError(err)
+	db, err := mysql.Open(cfg.Database.DSN)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "finisafricae:", err)
+		os.Exit(1)
+	}
 	defer db.Close()
 
-	mysql.InitDB(db)
-
 	//Initialize services and inject the db
-	us := &mysql.UserService{DB: db}
-	bs := &mysql.BookService{DB: db}
-	ss := &mysql.SessionService{DB: db}
-
-	//Http router
-	http.Handle("/", &handler.IndexHandler{UserService: us, SessionService: ss, Templates: Templates})
-	http.Handle("/home", &handler.HomeHandler{UserService: us, SessionService: ss, BookService: bs, Templates: Templates})
-	http.Handle("/book", &handler.BookHandler{UserService: us, SessionService: ss, Templates: Templates})
-	http.Handle("/newbook", &handler.NewBookHandler{UserService: us, SessionService: ss, BookService: bs, Templates: Templates})
-	http.Handle("/savebook", &handler.SaveBookHandler{UserService: us, SessionService: ss, BookService: bs, Templates: Templates})
-	http.Handle("/login", &handler.LoginHandler{UserService: us, SessionService: ss, Templates: Templates})
-	http.Handle("/logout", &handler.LogoutHandler{UserService: us, SessionService: ss})
-	http.Handle("/signup", &handler.SignupHandler{UserService: us, SessionService: ss, Templates: Templates})
-	http.Handle("/user", &handler.UserHandler{UserService: us, SessionService: ss, Templates: Templates})
-	http.Handle("/updatepassword", &handler.UpdatePasswordHandler{UserService: us, SessionService: ss, Templates: Templates})
-	http.Handle("/updateemail", &handler.UpdateEmailHandler{UserService: us, SessionService: ss, Templates: Templates})
-	http.Handle("/share", &handler.ShareHandler{UserService: us, SessionService: ss, Templates: Templates})
-	http.Handle("/favicon.ico", http.NotFoundHandler())
-	http.ListenAndServe(":8080", nil)
+	s := &services{
+		db:             db,
+		UserService:    &mysql.UserService{DB: db},
+		BookService:    &mysql.BookService{DB: db},
+		SessionService: &mysql.SessionService{DB: db},
+
+		PersistentTokenService: &mysql.PersistentTokenService{DB: db},
+		OneTimeTokenService:    &mysql.OneTimeTokenService{DB: db},
+		LoginAttemptService:    &mysql.LoginAttemptService{DB: db},
+		APITokenService:        &mysql.APITokenService{DB: db},
+		IdentityService:        &mysql.IdentityService{DB: db},
+		AuditService:           &mysql.AuditService{DB: db},
+		PublicLibraryService:   &mysql.PublicLibraryService{DB: db},
+	}
+	if cfg.Lockout.Store == "memory" {
+		s.LoginAttemptService = &inmem.LoginAttemptService{}
+	}
+	if cfg.Audit.Store == "memory" {
+		s.AuditService = &inmem.AuditService{}
+	}
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	switch cmd {
+	case "serve":
+		err = runServe(s, cfg, args)
+	case "migrate":
+		err = mysql.InitDB(db)
+	case "user":
+		err = runUser(s, cfg, args)
+	case "books":
+		err = runBooks(s, args)
+	case "sessions":
+		err = runSessions(s, cfg, args)
+	default:
+		err = fmt.Errorf("unknown command %q", cmd)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "finisafricae:", err)
+		os.Exit(1)
+	}
+}
+
+//loadConfig returns the default settings overridden by the config file at path, if any, and the environment
+func loadConfig(path string) (*config.Config, error) {
+	cfg := config.Default()
+	if path != "" {
+		if err := cfg.LoadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.LoadEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+//subcommand splits args into a subcommand and its arguments
+func subcommand(args []string) (string, []string) {
+	if len(args) == 0 {
+		return "", nil
+	}
+	return args[0], args[1:]
+}
+
+//passwordPolicy returns the policy new passwords must follow. It fails if the breached password directory isn't usable.
+func passwordPolicy(cfg *config.Config) (*finisafricae.PasswordPolicy, error) {
+	p := &finisafricae.PasswordPolicy{MinLength: cfg.Password.MinLength, MaxLength: cfg.Password.MaxLength}
+	if cfg.Password.BreachedDir != "" {
+		d, err := pwned.Open(cfg.Password.BreachedDir)
+		if err != nil {
+			return nil, fmt.Errorf("password.breached_dir: %v", err)
+		}
+		p.Breached = d
+	}
+	return p, nil
+}
+
+//passwordHasher returns the hasher of new passwords
+func passwordHasher(cfg *config.Config) finisafricae.PasswordHasher {
+	return &bcrypt.Hasher{Cost: cfg.Password.BcryptCost}
 }
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+	"github.com/madskrogh/finisafricae/config"
+	handler "github.com/madskrogh/finisafricae/http"
+	"github.com/madskrogh/finisafricae/lockout"
+	"github.com/madskrogh/finisafricae/mail"
+	"github.com/madskrogh/finisafricae/mysql"
+	"github.com/madskrogh/finisafricae/oidc"
+	"github.com/madskrogh/finisafricae/reaper"
+	"github.com/madskrogh/finisafricae/static"
+	tmpl "github.com/madskrogh/finisafricae/templates"
+)
+
+//runServe creates the database tables if needed and starts the web server and the session reaper.
+//On SIGINT or SIGTERM it stops accepting connections, lets running requests finish and returns.
+func runServe(s *services, cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.StringVar(&cfg.HTTP.Addr, "addr", cfg.HTTP.Addr, "address to listen on")
+	fs.BoolVar(&cfg.HTTP.Dev, "dev", cfg.HTTP.Dev, "reload templates and static files from the -source directory on every request")
+	fs.StringVar(&cfg.HTTP.Source, "source", cfg.HTTP.Source, "source checkout holding the templates and static directories, used with -dev")
+	fs.StringVar(&cfg.HTTP.Theme, "theme", cfg.HTTP.Theme, "directory with templates and static files overriding the built-in ones")
+	fs.DurationVar(&cfg.Session.IdleTimeout.Duration, "session-idle-timeout", cfg.Session.IdleTimeout.Duration, "how long a session may be idle")
+	fs.DurationVar(&cfg.Session.AbsoluteTimeout.Duration, "session-absolute-timeout", cfg.Session.AbsoluteTimeout.Duration, "how long a session lasts after login")
+	fs.BoolVar(&cfg.Session.SecureCookie, "secure-cookie", cfg.Session.SecureCookie, "only send the session cookie over https")
+	fs.DurationVar(&cfg.Session.RememberFor.Duration, "session-remember-for", cfg.Session.RememberFor.Duration, "how long \"remember me\" logins last")
+	fs.DurationVar(&cfg.Session.ReapInterval.Duration, "session-reap-interval", cfg.Session.ReapInterval.Duration, "how often expired sessions are deleted")
+	fs.StringVar(&cfg.HTTP.MetricsAddr, "metrics-addr", cfg.HTTP.MetricsAddr, "address serving metrics at /debug/vars, disabled if empty")
+	fs.Parse(args)
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	templates, assets, err := loadUI(cfg)
+	if err != nil {
+		return err
+	}
+	mailer, err := newMailer(cfg)
+	if err != nil {
+		return err
+	}
+	policy, err := passwordPolicy(cfg)
+	if err != nil {
+		return err
+	}
+	if err := mysql.InitDB(s.db); err != nil {
+		return err
+	}
+	us, bs, ss, ots := s.UserService, s.BookService, s.SessionService, s.OneTimeTokenService
+	sm := &handler.SessionManager{
+		SessionService:  ss,
+		IdleTimeout:     cfg.Session.IdleTimeout.Duration,
+		AbsoluteTimeout: cfg.Session.AbsoluteTimeout.Duration,
+		SecureCookie:    cfg.Session.SecureCookie,
+
+		PersistentTokenService: s.PersistentTokenService,
+		RememberFor:            cfg.Session.RememberFor.Duration,
+	}
+	audit := &handler.Auditor{AuditService: s.AuditService}
+	lock := &lockout.Limiter{
+		LoginAttemptService: s.LoginAttemptService,
+		Account:             lockout.Policy{Free: cfg.Lockout.AccountFailures, Backoff: cfg.Lockout.Backoff.Duration, MaxBackoff: cfg.Lockout.MaxBackoff.Duration, Forget: cfg.Lockout.Forget.Duration},
+		Address:             lockout.Policy{Free: cfg.Lockout.AddressFailures, Backoff: cfg.Lockout.Backoff.Duration, MaxBackoff: cfg.Lockout.MaxBackoff.Duration, Forget: cfg.Lockout.Forget.Duration},
+		OnLock:              func(a *finisafricae.LoginAttempt) { recordLockout(s, a) },
+	}
+	links := &handler.LinkMailer{OneTimeTokenService: ots, Mailer: mailer, BaseURL: strings.TrimSuffix(cfg.HTTP.BaseURL, "/")}
+	passwords := passwordHasher(cfg)
+	auth := &handler.Authenticator{UserService: us, Sessions: sm, APITokenService: s.APITokenService, Audit: audit}
+	guest, user := handler.RequireGuest, handler.RequireAuth
+	//member wraps handlers changing the library, which guests can't. self wraps the settings only users themselves can change,
+	//not admins acting as them.
+	member := func(h http.Handler) http.Handler {
+		return user(handler.RequireRole(templates, finisafricae.RoleMember, h))
+	}
+	self := func(h http.Handler) http.Handler { return user(handler.RequireSelf(templates, h)) }
+
+	//Http router. Handlers are wrapped by guest (logged out users only) or user (logged in users only).
+	mux := http.NewServeMux()
+	mux.Handle("/", guest(&handler.IndexHandler{Templates: templates}))
+	mux.Handle("/login", guest(&handler.LoginHandler{UserService: us, OneTimeTokenService: ots, Sessions: sm, Links: links, VerifyFor: cfg.Links.VerifyFor.Duration, Lockout: lock, Passwords: passwords, Audit: audit, Templates: templates}))
+	mux.Handle("/login/totp", guest(&handler.TOTPLoginHandler{UserService: us, OneTimeTokenService: ots, Sessions: sm, Lockout: lock, Audit: audit, Templates: templates}))
+	mux.Handle("/signup", guest(&handler.SignupHandler{UserService: us, Links: links, VerifyFor: cfg.Links.VerifyFor.Duration, Policy: policy, Passwords: passwords, Templates: templates}))
+	mux.Handle("/forgot", guest(&handler.ForgotPasswordHandler{UserService: us, Links: links, ResetFor: cfg.Links.ResetFor.Duration, Templates: templates}))
+	mux.Handle("/reset", guest(&handler.ResetPasswordHandler{UserService: us, OneTimeTokenService: ots, Sessions: sm, Policy: policy, Passwords: passwords, Audit: audit, Templates: templates}))
+	mux.Handle("/home", user(&handler.HomeHandler{BookService: bs, Templates: templates}))
+	mux.Handle("/book", user(&handler.BookHandler{BookService: bs, Templates: templates}))
+	mux.Handle("/newbook", member(&handler.NewBookHandler{Templates: templates}))
+	mux.Handle("/savebook", member(&handler.SaveBookHandler{BookService: bs, Audit: audit, Templates: templates}))
+	mux.Handle("/import", member(&handler.ImportHandler{BookService: bs, Audit: audit, Templates: templates}))
+	mux.Handle("/export", user(&handler.ExportHandler{BookService: bs, Templates: templates}))
+	mux.Handle("/logout", user(&handler.LogoutHandler{Sessions: sm, Templates: templates}))
+	mux.Handle("/totp", self(&handler.TOTPHandler{UserService: us, OneTimeTokenService: ots, Sessions: sm, Passwords: passwords, Templates: templates}))
+	mux.Handle("/sessions", self(&handler.SessionsHandler{Sessions: sm, Audit: audit, Templates: templates}))
+	mux.Handle("/user", user(&handler.UserHandler{Templates: templates}))
+	mux.Handle("/updatepassword", self(&handler.UpdatePasswordHandler{UserService: us, Sessions: sm, Policy: policy, Passwords: passwords, Audit: audit, Templates: templates}))
+	mux.Handle("/updateusername", self(&handler.UpdateUsernameHandler{UserService: us, Passwords: passwords, Audit: audit, Templates: templates}))
+	//Profiles are public
+	mux.Handle("/u/", &handler.ProfileHandler{UserService: us, PublicLibraryService: s.PublicLibraryService, BookService: bs, Templates: templates})
+	mux.Handle("/updateemail", self(&handler.UpdateEmailHandler{UserService: us, Links: links, VerifyFor: cfg.Links.VerifyFor.Duration, Passwords: passwords, Templates: templates}))
+	//Verification links are followed logged in or not
+	mux.Handle("/verify", &handler.VerifyEmailHandler{UserService: us, OneTimeTokenService: ots, Sessions: sm, Audit: audit, Templates: templates})
+	mux.Handle("/share", member(&handler.ShareHandler{PublicLibraryService: s.PublicLibraryService, Audit: audit, BaseURL: links.BaseURL, Templates: templates}))
+	//Published libraries are read by anyone with the link
+	mux.Handle("/library/", &handler.LibraryHandler{PublicLibraryService: s.PublicLibraryService, UserService: us, BookService: bs, Templates: templates})
+	mux.Handle("/deleteaccount", self(&handler.DeleteAccountHandler{UserService: us, Sessions: sm, Passwords: passwords, Mailer: mailer, DeleteAfter: cfg.Account.DeleteAfter.Duration, Templates: templates}))
+	mux.Handle("/tokens", self(&handler.TokensHandler{APITokenService: s.APITokenService, Templates: templates}))
+	booksAPI := user(&handler.BooksAPIHandler{BookService: bs, Audit: audit})
+	admin := &handler.AdminHandler{UserService: us, BookService: bs, Sessions: sm, Links: links, Passwords: passwords, ResetFor: cfg.Links.AdminResetFor.Duration, Audit: audit, Templates: templates}
+	mux.Handle("/admin", self(handler.RequireRole(templates, finisafricae.RoleAdmin, admin)))
+	//Admins acting as someone else must be able to stop, so the handler checks the role itself
+	mux.Handle("/admin/impersonate", user(&handler.ImpersonateHandler{UserService: us, Sessions: sm, Audit: audit, Templates: templates}))
+	mux.Handle("/admin/audit", self(handler.RequireRole(templates, finisafricae.RoleAdmin, &handler.AuditHandler{AuditService: s.AuditService, UserService: us, Templates: templates})))
+	mux.Handle("/activity", user(&handler.ActivityHandler{AuditService: s.AuditService, Templates: templates}))
+	mux.Handle("/api/books", booksAPI)
+	mux.Handle("/api/books/", booksAPI)
+
+	//Single sign-on, if configured. Accounts are linked when logged in and signed in with when logged out.
+	var app http.Handler = mux
+	if cfg.OIDC.Issuer != "" {
+		provider, err := oidc.New(context.Background(), cfg.OIDC.Name, cfg.OIDC.Issuer, cfg.OIDC.ClientID, cfg.OIDC.ClientSecret, strings.TrimSuffix(cfg.HTTP.BaseURL, "/")+"/login/oidc/callback")
+		if err != nil {
+			return err
+		}
+		sso := &handler.OIDCHandler{UserService: us, IdentityService: s.IdentityService, OneTimeTokenService: ots, Sessions: sm, Provider: provider, Passwords: passwords, Audit: audit, Templates: templates}
+		mux.Handle("/login/oidc", sso)
+		mux.Handle("/login/oidc/callback", sso)
+		mux.Handle("/identities", self(&handler.IdentitiesHandler{IdentityService: s.IdentityService, Provider: provider, Templates: templates}))
+		app = handler.SignIn(provider.Name, mux)
+	}
+
+	//Static files are served without authentication and CSRF checks
+	root := http.NewServeMux()
+	root.Handle("/", auth.Authenticate(handler.CSRF(templates, sm.SecureCookie, app)))
+	root.Handle("/static/", assets)
+	root.Handle("/favicon.ico", http.NotFoundHandler())
+
+	servers := []*http.Server{{Addr: cfg.HTTP.Addr, Handler: handler.Recover(templates, root)}}
+	if cfg.HTTP.MetricsAddr != "" {
+		metrics := http.NewServeMux()
+		metrics.Handle("/debug/vars", expvar.Handler())
+		servers = append(servers, &http.Server{Addr: cfg.HTTP.MetricsAddr, Handler: metrics})
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r := &reaper.Reaper{
+			UserService:            us,
+			SessionService:         ss,
+			PersistentTokenService: s.PersistentTokenService,
+			OneTimeTokenService:    s.OneTimeTokenService,
+			LoginAttemptService:    s.LoginAttemptService,
+			LoginAttemptsFor:       lock.Forget(),
+			Interval:               cfg.Session.ReapInterval.Duration,
+			IdleTimeout:            cfg.Session.IdleTimeout.Duration,
+		}
+		r.Run(ctx)
+	}()
+	//The first server to fail stops the others
+	errc := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			errc <- srv.ListenAndServe()
+		}(srv)
+	}
+	select {
+	case <-ctx.Done():
+		log.Print("shutting down")
+	case err = <-errc:
+		stop()
+	}
+	sctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for _, srv := range servers {
+		if serr := srv.Shutdown(sctx); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	wg.Wait()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+//recordLockout records the lockout of a in the audit log. Lockouts of an account are about its user, lockouts of a client
+//address are about no user.
+func recordLockout(s *services, a *finisafricae.LoginAttempt) {
+	e := &finisafricae.AuditEvent{
+		Time:   time.Now().UTC(),
+		Type:   finisafricae.AuditLockout,
+		Detail: fmt.Sprintf("Logins refused after %d failures until %s", a.Failures, a.LockedUntil.Format(time.RFC3339)),
+	}
+	//Keys are "account:" followed by an email or "address:" followed by an IP address
+	switch kind, v, _ := strings.Cut(a.Key, ":"); kind {
+	case "account":
+		if u, err := s.UserService.UserFromEmail(v); err == nil {
+			e.UserID = u.ID
+		} else {
+			e.Detail += " for " + v
+		}
+	case "address":
+		e.IP = v
+	}
+	if err := s.AuditService.RecordAuditEvent(e); err != nil {
+		log.Printf("recording lockout of %s: %v", a.Key, err)
+	}
+}
+
+//newMailer returns a mailer sending through the configured SMTP server, or writing mail to the configured file or standard error without one
+func newMailer(cfg *config.Config) (finisafricae.Mailer, error) {
+	if cfg.Mail.SMTPAddr != "" {
+		return &mail.SMTPMailer{Addr: cfg.Mail.SMTPAddr, Username: cfg.Mail.Username, Password: cfg.Mail.Password, From: cfg.Mail.From}, nil
+	}
+	if cfg.Mail.File == "" {
+		return &mail.LogMailer{W: os.Stderr, From: cfg.Mail.From}, nil
+	}
+	f, err := os.OpenFile(cfg.Mail.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &mail.LogMailer{W: f, From: cfg.Mail.From}, nil
+}
+
+//loadUI returns the templates and the handler for static files. They are embedded in the binary, or read from
+//the source checkout on every request in dev mode, and overridden by the files of the theme directory.
+func loadUI(cfg *config.Config) (handler.Templates, http.Handler, error) {
+	t, s := fs.FS(tmpl.FS), fs.FS(static.FS)
+	if cfg.HTTP.Dev {
+		t = os.DirFS(filepath.Join(cfg.HTTP.Source, "templates"))
+		s = os.DirFS(filepath.Join(cfg.HTTP.Source, "static"))
+	}
+	tl, sl := []fs.FS{t}, []fs.FS{s}
+	if cfg.HTTP.Theme != "" {
+		tl = append(tl, os.DirFS(filepath.Join(cfg.HTTP.Theme, "templates")))
+		sl = append(sl, os.DirFS(filepath.Join(cfg.HTTP.Theme, "static")))
+	}
+	if cfg.HTTP.Dev {
+		return handler.ReloadingTemplates(tl), handler.StaticHandler(sl...), nil
+	}
+	//Templates are parsed once at startup, so broken templates are found before serving
+	templates, err := handler.ParseTemplates(tl...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return templates, handler.StaticHandler(sl...), nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/madskrogh/finisafricae/config"
+	handler "github.com/madskrogh/finisafricae/http"
+)
+
+//runSessions runs the sessions subcommand purge
+func runSessions(s *services, cfg *config.Config, args []string) error {
+	cmd, args := subcommand(args)
+	if cmd != "purge" {
+		return fmt.Errorf("unknown sessions command %q", cmd)
+	}
+	fs := flag.NewFlagSet("sessions purge", flag.ExitOnError)
+	all := fs.Bool("all", false, "delete all sessions, logging everybody out")
+	idle := fs.Duration("idle", cfg.Session.IdleTimeout.Duration, "delete sessions idle for longer than this")
+	absolute := fs.Duration("absolute", cfg.Session.AbsoluteTimeout.Duration, "delete sessions created longer ago than this")
+	fs.Parse(args)
+
+	ses, err := s.SessionService.Sessions()
+	if err != nil {
+		return err
+	}
+	n := 0
+	for _, se := range ses {
+		if !*all && time.Since(se.LastSeen) <= *idle && time.Since(se.Created) <= *absolute {
+			continue
+		}
+		if err := s.SessionService.DeleteSession(se.ID); err != nil {
+			return err
+		}
+		n++
+	}
+	fmt.Printf("purged %d of %d sessions\n", n, len(ses))
+	return nil
+}
+
+//deleteSessions deletes all sessions and remembered logins of the user with the given id, the same way the server logs
+//users out everywhere
+func deleteSessions(s *services, userID string) error {
+	m := &handler.SessionManager{SessionService: s.SessionService, PersistentTokenService: s.PersistentTokenService}
+	return m.LogoutUser(userID)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+	"github.com/madskrogh/finisafricae/config"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+//runUser runs the user subcommands create, list, delete, reset-password, role and disable
+func runUser(s *services, cfg *config.Config, args []string) error {
+	cmd, args := subcommand(args)
+	fs := flag.NewFlagSet("user "+cmd, flag.ExitOnError)
+	email := fs.String("email", "", "email of the user")
+	switch cmd {
+	case "create":
+		uname := fs.String("uname", "", "username of the new user")
+		password := fs.String("password", "", "password of the new user, read from standard input if empty")
+		role := fs.String("role", finisafricae.RoleMember, "role of the new user, one of "+strings.Join(finisafricae.Roles, ", "))
+		fs.Parse(args)
+		if *email == "" || *uname == "" {
+			return errors.New("user create: -email and -uname are required")
+		}
+		if err := finisafricae.ValidateUsername(*uname); err != nil {
+			return err
+		}
+		p, err := readPassword(*password)
+		if err != nil {
+			return err
+		}
+		uID, err := uuid.NewV4()
+		if err != nil {
+			return err
+		}
+		//Users created by an administrator need not confirm their email
+		u := finisafricae.User{ID: uID.String(), Uname: *uname, Email: *email, Verified: true, Role: *role}
+		policy, err := passwordPolicy(cfg)
+		if err != nil {
+			return err
+		}
+		if err := policy.Check(p, &u); err != nil {
+			return err
+		}
+		if u.Password, err = passwordHasher(cfg).Hash(p); err != nil {
+			return err
+		}
+		if err := s.UserService.CreateUser(&u); err != nil {
+			return err
+		}
+		fmt.Println(u.ID)
+		return nil
+
+	case "list":
+		fs.Parse(args)
+		us, err := s.UserService.Users()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tUSERNAME\tEMAIL\tROLE\tVERIFIED\tDISABLED\tDELETE AT")
+		for _, u := range us {
+			deleteAt := "-"
+			if !u.DeleteAt.IsZero() {
+				deleteAt = u.DeleteAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%t\t%t\t%s\n", u.ID, u.Uname, u.Email, u.Role, u.Verified, u.Disabled, deleteAt)
+		}
+		return tw.Flush()
+
+	case "delete":
+		fs.Parse(args)
+		u, err := userFromEmail(s, *email)
+		if err != nil {
+			return err
+		}
+		//The books, sessions, tokens and linked accounts of the user are deleted with it
+		if err := s.UserService.DeleteUser(u.ID); err != nil {
+			return err
+		}
+		recordCLI(s, &finisafricae.AuditEvent{Type: finisafricae.AuditUserDelete, UserID: u.ID, Detail: u.Uname + " " + u.Email})
+		return nil
+
+	case "reset-password":
+		password := fs.String("password", "", "new password, read from standard input if empty")
+		fs.Parse(args)
+		u, err := userFromEmail(s, *email)
+		if err != nil {
+			return err
+		}
+		p, err := readPassword(*password)
+		if err != nil {
+			return err
+		}
+		policy, err := passwordPolicy(cfg)
+		if err != nil {
+			return err
+		}
+		if err := policy.Check(p, u); err != nil {
+			return err
+		}
+		if u.Password, err = passwordHasher(cfg).Hash(p); err != nil {
+			return err
+		}
+		if err := s.UserService.UpdateUser(u); err != nil {
+			return err
+		}
+		recordCLI(s, &finisafricae.AuditEvent{Type: finisafricae.AuditPasswordReset, UserID: u.ID, Detail: "Set on the command line"})
+		//The user is logged out everywhere
+		return deleteSessions(s, u.ID)
+
+	case "role":
+		role := fs.String("role", "", "new role, one of "+strings.Join(finisafricae.Roles, ", "))
+		fs.Parse(args)
+		u, err := userFromEmail(s, *email)
+		if err != nil {
+			return err
+		}
+		old := u.Role
+		u.Role = *role
+		if err := u.Validate(); err != nil {
+			return err
+		}
+		if err := s.UserService.UpdateUser(u); err != nil {
+			return err
+		}
+		recordCLI(s, &finisafricae.AuditEvent{Type: finisafricae.AuditRoleChange, UserID: u.ID, Detail: old + " to " + u.Role})
+		return nil
+
+	case "disable", "enable":
+		fs.Parse(args)
+		u, err := userFromEmail(s, *email)
+		if err != nil {
+			return err
+		}
+		u.Disabled = cmd == "disable"
+		if err := s.UserService.UpdateUser(u); err != nil {
+			return err
+		}
+		typ := finisafricae.AuditEnable
+		if u.Disabled {
+			typ = finisafricae.AuditDisable
+		}
+		recordCLI(s, &finisafricae.AuditEvent{Type: typ, UserID: u.ID})
+		if !u.Disabled {
+			return nil
+		}
+		return deleteSessions(s, u.ID)
+	}
+	return fmt.Errorf("unknown user command %q", cmd)
+}
+
+//recordCLI records e as caused now by an administrator on the command line. An event that can't be recorded doesn't fail
+//the command, which has already taken effect, it is logged instead.
+func recordCLI(s *services, e *finisafricae.AuditEvent) {
+	e.Time = time.Now().UTC()
+	e.ActorID = finisafricae.AuditActorCLI
+	if err := s.AuditService.RecordAuditEvent(e); err != nil {
+		log.Printf("recording %s audit event of user %s: %v", e.Type, e.UserID, err)
+	}
+}
+
+//userFromEmail returns the user with the given email
+func userFromEmail(s *services, email string) (*finisafricae.User, error) {
+	if email == "" {
+		return nil, errors.New("-email is required")
+	}
+	u, err := s.UserService.UserFromEmail(email)
+	if err != nil {
+		return nil, fmt.Errorf("no user with email %s: %v", email, err)
+	}
+	return u, nil
+}
+
+//readPassword returns p, or a line read from standard input if p is empty
+func readPassword(p string) (string, error) {
+	if p == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		p = strings.TrimRight(line, "\r\n")
+	}
+	if p == "" {
+		return "", errors.New("the password must not be empty")
+	}
+	return p, nil
+}
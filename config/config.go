@@ -0,0 +1,368 @@
+//Package config loads the settings of the application from YAML or TOML files and the environment
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+//Config holds the settings of the application. Settings are read, in increasing order of precedence,
+//from Default, a config file, FINISAFRICAE_* environment variables and command line flags.
+type Config struct {
+	Database struct {
+		//DSN is the MySQL data source name
+		DSN string `yaml:"dsn" toml:"dsn"`
+	} `yaml:"database" toml:"database"`
+
+	HTTP struct {
+		//Addr is the address the server listens on
+		Addr string `yaml:"addr" toml:"addr"`
+		//BaseURL is the URL users reach the server at, used for links in mail
+		BaseURL string `yaml:"base_url" toml:"base_url"`
+		//Dev makes the server read templates and static files from Source on every request instead of using the embedded copies
+		Dev bool `yaml:"dev" toml:"dev"`
+		//Source is the directory holding the templates and static directories of a source checkout, used in Dev mode
+		Source string `yaml:"source" toml:"source"`
+		//Theme is an optional directory with templates and static directories whose files replace the built-in ones of the same name
+		Theme string `yaml:"theme" toml:"theme"`
+		//MetricsAddr is an optional address serving expvar metrics at /debug/vars. Keep it private.
+		MetricsAddr string `yaml:"metrics_addr" toml:"metrics_addr"`
+	} `yaml:"http" toml:"http"`
+
+	Session struct {
+		//IdleTimeout is how long a session may be idle before the user must login again
+		IdleTimeout Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+		//AbsoluteTimeout is how long a session lasts after login, however active the user is
+		AbsoluteTimeout Duration `yaml:"absolute_timeout" toml:"absolute_timeout"`
+		//SecureCookie restricts the session cookie to https. Disable it only when serving plain http, such as in development.
+		SecureCookie bool `yaml:"secure_cookie" toml:"secure_cookie"`
+		//RememberFor is how long a "remember me" login lasts
+		RememberFor Duration `yaml:"remember_for" toml:"remember_for"`
+		//ReapInterval is how often expired sessions are deleted
+		ReapInterval Duration `yaml:"reap_interval" toml:"reap_interval"`
+	} `yaml:"session" toml:"session"`
+
+	Password struct {
+		//MinLength is the fewest characters a new password may have
+		MinLength int `yaml:"min_length" toml:"min_length"`
+		//MaxLength is the most bytes a new password may have, at most 72 as bcrypt ignores the rest
+		MaxLength int `yaml:"max_length" toml:"max_length"`
+		//BreachedDir is an optional directory of breached password hashes split by SHA-1 prefix, such as a download
+		//of Pwned Passwords. New passwords found in it are refused.
+		BreachedDir string `yaml:"breached_dir" toml:"breached_dir"`
+		//BcryptCost is the cost of new password hashes. Each step up doubles the time logins take. Hashes of a lower
+		//cost are replaced when their users login.
+		BcryptCost int `yaml:"bcrypt_cost" toml:"bcrypt_cost"`
+	} `yaml:"password" toml:"password"`
+
+	Account struct {
+		//DeleteAfter is how long accounts are kept after their users ask for them to be deleted. Logging in before then keeps the account.
+		DeleteAfter Duration `yaml:"delete_after" toml:"delete_after"`
+	} `yaml:"account" toml:"account"`
+
+	Links struct {
+		//ResetFor is how long the link of a password reset users ask for works
+		ResetFor Duration `yaml:"reset_for" toml:"reset_for"`
+		//AdminResetFor is how long the link of a password reset forced by an admin works. The user may not be expecting it.
+		AdminResetFor Duration `yaml:"admin_reset_for" toml:"admin_reset_for"`
+		//VerifyFor is how long the links confirming emails work
+		VerifyFor Duration `yaml:"verify_for" toml:"verify_for"`
+	} `yaml:"links" toml:"links"`
+
+	Lockout struct {
+		//Store is where failed logins are counted: "mysql", shared between servers and kept across restarts, or "memory"
+		Store string `yaml:"store" toml:"store"`
+		//AccountFailures and AddressFailures are the failed logins allowed per account and per client address before lockouts begin
+		AccountFailures int `yaml:"account_failures" toml:"account_failures"`
+		AddressFailures int `yaml:"address_failures" toml:"address_failures"`
+		//Backoff is the first lockout, which doubles with every further failure up to MaxBackoff
+		Backoff    Duration `yaml:"backoff" toml:"backoff"`
+		MaxBackoff Duration `yaml:"max_backoff" toml:"max_backoff"`
+		//Forget is how long after the last failure the failures are forgotten
+		Forget Duration `yaml:"forget" toml:"forget"`
+	} `yaml:"lockout" toml:"lockout"`
+
+	Audit struct {
+		//Store is where the audit log is kept: "mysql", shared between servers and kept across restarts, or "memory"
+		Store string `yaml:"store" toml:"store"`
+	} `yaml:"audit" toml:"audit"`
+
+	Mail struct {
+		//SMTPAddr is the host:port of the SMTP server. Without it mail is written to File instead of being sent.
+		SMTPAddr string `yaml:"smtp_addr" toml:"smtp_addr"`
+		Username string `yaml:"username" toml:"username"`
+		Password string `yaml:"password" toml:"password"`
+		//From is the sender address of mail
+		From string `yaml:"from" toml:"from"`
+		//File receives the mail when there is no SMTP server. Empty means standard error.
+		File string `yaml:"file" toml:"file"`
+	} `yaml:"mail" toml:"mail"`
+
+	OIDC struct {
+		//Issuer is the URL of the OpenID Connect provider users can sign in with. Empty disables single sign-on.
+		Issuer string `yaml:"issuer" toml:"issuer"`
+		//ClientID and ClientSecret are the credentials the provider issued for the application
+		ClientID     string `yaml:"client_id" toml:"client_id"`
+		ClientSecret string `yaml:"client_secret" toml:"client_secret"`
+		//Name is shown to users, as in "Sign in with Name"
+		Name string `yaml:"name" toml:"name"`
+	} `yaml:"oidc" toml:"oidc"`
+}
+
+//Duration is a time.Duration read from strings such as "300s" or "5m"
+type Duration struct {
+	time.Duration
+}
+
+//UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+//MarshalText implements encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+//Default returns the default settings
+func Default() *Config {
+	c := &Config{}
+	c.Database.DSN = "user:password@/database"
+	c.HTTP.Addr = ":8080"
+	c.HTTP.BaseURL = "http://localhost:8080"
+	c.HTTP.Source = "."
+	c.Session.IdleTimeout = Duration{30 * time.Minute}
+	c.Session.AbsoluteTimeout = Duration{24 * time.Hour}
+	c.Session.SecureCookie = true
+	c.Session.RememberFor = Duration{30 * 24 * time.Hour}
+	c.Session.ReapInterval = Duration{10 * time.Minute}
+	c.Password.MinLength = 8
+	c.Password.MaxLength = 72
+	c.Password.BcryptCost = 12
+	c.Account.DeleteAfter = Duration{14 * 24 * time.Hour}
+	c.Links.ResetFor = Duration{time.Hour}
+	c.Links.AdminResetFor = Duration{24 * time.Hour}
+	c.Links.VerifyFor = Duration{48 * time.Hour}
+	c.Lockout.Store = "mysql"
+	c.Lockout.AccountFailures = 5
+	c.Lockout.AddressFailures = 20
+	c.Lockout.Backoff = Duration{30 * time.Second}
+	c.Lockout.MaxBackoff = Duration{time.Hour}
+	c.Lockout.Forget = Duration{24 * time.Hour}
+	c.Audit.Store = "mysql"
+	c.Mail.From = "finisafricae@localhost"
+	c.OIDC.Name = "SSO"
+	return c
+}
+
+//LoadFile reads settings from a YAML (.yaml, .yml) or TOML (.toml) file. Settings missing from the file are left unchanged.
+func (c *Config) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	case ".toml":
+		err = toml.Unmarshal(data, c)
+	default:
+		return fmt.Errorf("config: unknown file type %s, use .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("config: %s: %v", path, err)
+	}
+	return nil
+}
+
+//env returns the settings read from each environment variable by LoadEnv
+func (c *Config) env() map[string]interface{} {
+	return map[string]interface{}{
+		"FINISAFRICAE_DSN":                      &c.Database.DSN,
+		"FINISAFRICAE_ADDR":                     &c.HTTP.Addr,
+		"FINISAFRICAE_BASE_URL":                 &c.HTTP.BaseURL,
+		"FINISAFRICAE_DEV":                      &c.HTTP.Dev,
+		"FINISAFRICAE_SOURCE":                   &c.HTTP.Source,
+		"FINISAFRICAE_THEME":                    &c.HTTP.Theme,
+		"FINISAFRICAE_METRICS_ADDR":             &c.HTTP.MetricsAddr,
+		"FINISAFRICAE_SESSION_IDLE_TIMEOUT":     &c.Session.IdleTimeout,
+		"FINISAFRICAE_SESSION_ABSOLUTE_TIMEOUT": &c.Session.AbsoluteTimeout,
+		"FINISAFRICAE_SESSION_SECURE_COOKIE":    &c.Session.SecureCookie,
+		"FINISAFRICAE_SESSION_REMEMBER_FOR":     &c.Session.RememberFor,
+		"FINISAFRICAE_SESSION_REAP_INTERVAL":    &c.Session.ReapInterval,
+		"FINISAFRICAE_PASSWORD_MIN_LENGTH":      &c.Password.MinLength,
+		"FINISAFRICAE_PASSWORD_MAX_LENGTH":      &c.Password.MaxLength,
+		"FINISAFRICAE_PASSWORD_BREACHED_DIR":    &c.Password.BreachedDir,
+		"FINISAFRICAE_PASSWORD_BCRYPT_COST":     &c.Password.BcryptCost,
+		"FINISAFRICAE_ACCOUNT_DELETE_AFTER":     &c.Account.DeleteAfter,
+		"FINISAFRICAE_LINKS_RESET_FOR":          &c.Links.ResetFor,
+		"FINISAFRICAE_LINKS_ADMIN_RESET_FOR":    &c.Links.AdminResetFor,
+		"FINISAFRICAE_LINKS_VERIFY_FOR":         &c.Links.VerifyFor,
+		"FINISAFRICAE_LOCKOUT_STORE":            &c.Lockout.Store,
+		"FINISAFRICAE_LOCKOUT_ACCOUNT_FAILURES": &c.Lockout.AccountFailures,
+		"FINISAFRICAE_LOCKOUT_ADDRESS_FAILURES": &c.Lockout.AddressFailures,
+		"FINISAFRICAE_LOCKOUT_BACKOFF":          &c.Lockout.Backoff,
+		"FINISAFRICAE_LOCKOUT_MAX_BACKOFF":      &c.Lockout.MaxBackoff,
+		"FINISAFRICAE_LOCKOUT_FORGET":           &c.Lockout.Forget,
+		"FINISAFRICAE_AUDIT_STORE":              &c.Audit.Store,
+		"FINISAFRICAE_SMTP_ADDR":                &c.Mail.SMTPAddr,
+		"FINISAFRICAE_SMTP_USERNAME":            &c.Mail.Username,
+		"FINISAFRICAE_SMTP_PASSWORD":            &c.Mail.Password,
+		"FINISAFRICAE_MAIL_FROM":                &c.Mail.From,
+		"FINISAFRICAE_MAIL_FILE":                &c.Mail.File,
+		"FINISAFRICAE_OIDC_ISSUER":              &c.OIDC.Issuer,
+		"FINISAFRICAE_OIDC_CLIENT_ID":           &c.OIDC.ClientID,
+		"FINISAFRICAE_OIDC_CLIENT_SECRET":       &c.OIDC.ClientSecret,
+		"FINISAFRICAE_OIDC_NAME":                &c.OIDC.Name,
+	}
+}
+
+//EnvNames returns the names of the environment variables read by LoadEnv, sorted
+func EnvNames() []string {
+	var names []string
+	for name := range (&Config{}).env() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//LoadEnv reads settings from the environment variables named by EnvNames. lookup is normally os.LookupEnv.
+func (c *Config) LoadEnv(lookup func(string) (string, bool)) error {
+	env := c.env()
+	for _, name := range EnvNames() {
+		v, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		var err error
+		switch p := env[name].(type) {
+		case *string:
+			*p = v
+		case *bool:
+			*p, err = strconv.ParseBool(v)
+		case *int:
+			*p, err = strconv.Atoi(v)
+		case *Duration:
+			err = p.UnmarshalText([]byte(v))
+		}
+		if err != nil {
+			return fmt.Errorf("config: %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+//Validate returns an error describing every invalid setting
+func (c *Config) Validate() error {
+	var errs []string
+	if c.Database.DSN == "" {
+		errs = append(errs, "database.dsn must be set")
+	}
+	if _, _, err := net.SplitHostPort(c.HTTP.Addr); err != nil {
+		errs = append(errs, fmt.Sprintf("http.addr %q is not a host:port address", c.HTTP.Addr))
+	}
+	if c.HTTP.Dev && !isDir(filepath.Join(c.HTTP.Source, "templates")) {
+		errs = append(errs, fmt.Sprintf("http.source %q has no templates directory", c.HTTP.Source))
+	}
+	if u, err := url.Parse(c.HTTP.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Sprintf("http.base_url %q is not an http or https URL", c.HTTP.BaseURL))
+	}
+	if c.HTTP.MetricsAddr != "" {
+		if _, _, err := net.SplitHostPort(c.HTTP.MetricsAddr); err != nil {
+			errs = append(errs, fmt.Sprintf("http.metrics_addr %q is not a host:port address", c.HTTP.MetricsAddr))
+		}
+	}
+	if c.HTTP.Theme != "" && !isDir(c.HTTP.Theme) {
+		errs = append(errs, fmt.Sprintf("http.theme %q is not a directory", c.HTTP.Theme))
+	}
+	if c.Session.IdleTimeout.Duration <= 0 {
+		errs = append(errs, "session.idle_timeout must be positive")
+	}
+	if c.Session.AbsoluteTimeout.Duration < c.Session.IdleTimeout.Duration {
+		errs = append(errs, "session.absolute_timeout must be at least session.idle_timeout")
+	}
+	if c.Session.RememberFor.Duration <= 0 {
+		errs = append(errs, "session.remember_for must be positive")
+	}
+	if c.Session.ReapInterval.Duration <= 0 {
+		errs = append(errs, "session.reap_interval must be positive")
+	}
+	if c.Password.MinLength < 1 {
+		errs = append(errs, "password.min_length must be at least 1")
+	}
+	if c.Password.MaxLength < c.Password.MinLength || c.Password.MaxLength > 72 {
+		errs = append(errs, "password.max_length must be at least password.min_length and at most 72")
+	}
+	if c.Password.BreachedDir != "" && !isDir(c.Password.BreachedDir) {
+		errs = append(errs, fmt.Sprintf("password.breached_dir %q is not a directory", c.Password.BreachedDir))
+	}
+	if c.Password.BcryptCost < 4 || c.Password.BcryptCost > 31 {
+		errs = append(errs, "password.bcrypt_cost must be between 4 and 31")
+	}
+	if c.Account.DeleteAfter.Duration < 0 {
+		errs = append(errs, "account.delete_after must not be negative")
+	}
+	if c.Links.ResetFor.Duration <= 0 || c.Links.AdminResetFor.Duration <= 0 || c.Links.VerifyFor.Duration <= 0 {
+		errs = append(errs, "links.reset_for, links.admin_reset_for and links.verify_for must be positive")
+	}
+	if c.Lockout.Store != "mysql" && c.Lockout.Store != "memory" {
+		errs = append(errs, fmt.Sprintf("lockout.store %q must be mysql or memory", c.Lockout.Store))
+	}
+	if c.Audit.Store != "mysql" && c.Audit.Store != "memory" {
+		errs = append(errs, fmt.Sprintf("audit.store %q must be mysql or memory", c.Audit.Store))
+	}
+	if c.Lockout.AccountFailures < 0 || c.Lockout.AddressFailures < 0 {
+		errs = append(errs, "lockout.account_failures and lockout.address_failures must not be negative")
+	}
+	if c.Lockout.Backoff.Duration <= 0 || c.Lockout.MaxBackoff.Duration < c.Lockout.Backoff.Duration {
+		errs = append(errs, "lockout.backoff must be positive and lockout.max_backoff at least lockout.backoff")
+	}
+	if c.Lockout.Forget.Duration <= 0 {
+		errs = append(errs, "lockout.forget must be positive")
+	}
+	if c.Mail.SMTPAddr != "" {
+		if _, _, err := net.SplitHostPort(c.Mail.SMTPAddr); err != nil {
+			errs = append(errs, fmt.Sprintf("mail.smtp_addr %q is not a host:port address", c.Mail.SMTPAddr))
+		}
+	}
+	if c.Mail.From == "" {
+		errs = append(errs, "mail.from must be set")
+	}
+	if c.OIDC.Issuer != "" {
+		if u, err := url.Parse(c.OIDC.Issuer); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("oidc.issuer %q is not an http or https URL", c.OIDC.Issuer))
+		}
+		if c.OIDC.ClientID == "" {
+			errs = append(errs, "oidc.client_id must be set with oidc.issuer")
+		}
+		if c.OIDC.Name == "" {
+			errs = append(errs, "oidc.name must be set with oidc.issuer")
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New("config: " + strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
@@ -0,0 +1,56 @@
+package finisafricae
+
+import "context"
+
+type contextKey int
+
+const (
+	userContextKey contextKey = iota
+	sessionContextKey
+	apiTokenContextKey
+	impersonatorContextKey
+)
+
+//NewContextWithUser returns a copy of ctx holding the authenticated user u
+func NewContextWithUser(ctx context.Context, u *User) context.Context {
+	return context.WithValue(ctx, userContextKey, u)
+}
+
+//UserFromContext returns the authenticated user of ctx, or nil if there is none
+func UserFromContext(ctx context.Context) *User {
+	u, _ := ctx.Value(userContextKey).(*User)
+	return u
+}
+
+//NewContextWithSession returns a copy of ctx holding the session s of the authenticated user
+func NewContextWithSession(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, sessionContextKey, s)
+}
+
+//SessionFromContext returns the session of ctx, or nil if there is none
+func SessionFromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionContextKey).(*Session)
+	return s
+}
+
+//NewContextWithAPIToken returns a copy of ctx holding the API token t the user is authenticated with
+func NewContextWithAPIToken(ctx context.Context, t *APIToken) context.Context {
+	return context.WithValue(ctx, apiTokenContextKey, t)
+}
+
+//APITokenFromContext returns the API token of ctx, or nil if the user is not authenticated with one
+func APITokenFromContext(ctx context.Context) *APIToken {
+	t, _ := ctx.Value(apiTokenContextKey).(*APIToken)
+	return t
+}
+
+//NewContextWithImpersonator returns a copy of ctx holding the admin u acting as the authenticated user
+func NewContextWithImpersonator(ctx context.Context, u *User) context.Context {
+	return context.WithValue(ctx, impersonatorContextKey, u)
+}
+
+//ImpersonatorFromContext returns the admin acting as the user of ctx, or nil if the user is acting themselves
+func ImpersonatorFromContext(ctx context.Context) *User {
+	u, _ := ctx.Value(impersonatorContextKey).(*User)
+	return u
+}
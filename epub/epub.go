@@ -0,0 +1,185 @@
+//Package epub creates books from the Dublin Core metadata of EPUB files and Calibre metadata.opf sidecars
+package epub
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//ErrNoPackage is returned when an EPUB file doesn't point to a package document
+var ErrNoPackage = errors.New("epub: no package document found")
+
+//pkg is the part of an OPF package document that is mapped to a book.
+//Elements and attributes are matched on their local name, so both EPUB 2 (opf:role, opf:scheme) and EPUB 3 metadata are read.
+type pkg struct {
+	Metadata struct {
+		Titles      []string     `xml:"title"`
+		Creators    []creator    `xml:"creator"`
+		Dates       []string     `xml:"date"`
+		Subjects    []string     `xml:"subject"`
+		Identifiers []identifier `xml:"identifier"`
+		Metas       []meta       `xml:"meta"`
+	} `xml:"metadata"`
+}
+
+type creator struct {
+	ID    string `xml:"id,attr"`
+	Role  string `xml:"role,attr"`
+	Value string `xml:",chardata"`
+}
+
+type identifier struct {
+	Scheme string `xml:"scheme,attr"`
+	Value  string `xml:",chardata"`
+}
+
+//meta holds EPUB 3 refinements, e.g. <meta refines="#creator01" property="role">aut</meta>
+type meta struct {
+	Refines  string `xml:"refines,attr"`
+	Property string `xml:"property,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type container struct {
+	Rootfiles []struct {
+		FullPath  string `xml:"full-path,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+var (
+	yearPattern = regexp.MustCompile(`[0-9]{4}`)
+	isbnPattern = regexp.MustCompile(`^(97[89])?[0-9]{9}[0-9Xx]$`)
+)
+
+const (
+	//minYear is the earliest year taken from a date. Calibre writes 0101-01-01T00:00:00+00:00 for books without a date,
+	//and no book was printed before then.
+	minYear = "1400"
+	//maxXMLSize is the most bytes of an xml file that are read. Files in an EPUB may unzip to far more than the EPUB,
+	//while package documents are rarely more than a few hundred kilobytes.
+	maxXMLSize = 4 << 20
+)
+
+//ReadOPF returns the book described by an OPF package document, such as a Calibre metadata.opf.
+//ID and UserID are left empty for the caller to fill in.
+func ReadOPF(r io.Reader) (*finisafricae.Book, error) {
+	var p pkg
+	if err := xml.NewDecoder(io.LimitReader(r, maxXMLSize)).Decode(&p); err != nil {
+		return nil, err
+	}
+	return p.book(), nil
+}
+
+//Read returns the book described by the package document of an EPUB file
+func Read(r io.ReaderAt, size int64) (*finisafricae.Book, error) {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	//META-INF/container.xml points to the package document
+	var c container
+	if err := decodeFile(z, "META-INF/container.xml", &c); err != nil {
+		return nil, err
+	}
+	for _, rf := range c.Rootfiles {
+		if rf.MediaType == "application/oebps-package+xml" || path.Ext(rf.FullPath) == ".opf" {
+			var p pkg
+			if err := decodeFile(z, rf.FullPath, &p); err != nil {
+				return nil, err
+			}
+			return p.book(), nil
+		}
+	}
+	return nil, ErrNoPackage
+}
+
+//decodeFile decodes the xml file with the given name in the zip archive into v. Files larger than maxXMLSize are cut
+//short, which makes them invalid.
+func decodeFile(z *zip.Reader, name string, v interface{}) error {
+	f, err := z.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return xml.NewDecoder(io.LimitReader(f, maxXMLSize)).Decode(v)
+}
+
+//book maps title, authors, publication year, subjects and ISBN of the package metadata to a book
+func (p *pkg) book() *finisafricae.Book {
+	m := p.Metadata
+	b := &finisafricae.Book{}
+	if len(m.Titles) > 0 {
+		b.Title = clean(m.Titles[0])
+	}
+	//Creators with no role are taken to be authors, other roles (editors, illustrators, ...) are left out
+	roles := make(map[string]string)
+	for _, mt := range m.Metas {
+		if mt.Property == "role" {
+			roles[strings.TrimPrefix(mt.Refines, "#")] = clean(mt.Value)
+		}
+	}
+	var authors []string
+	for _, c := range m.Creators {
+		role := c.Role
+		if role == "" {
+			role = roles[c.ID]
+		}
+		if role == "" || role == "aut" {
+			authors = append(authors, clean(c.Value))
+		}
+	}
+	b.Author = strings.Join(authors, " & ")
+	for _, d := range m.Dates {
+		//Years of the same length compare as strings
+		if y := yearPattern.FindString(d); y >= minYear {
+			b.Year = y
+			break
+		}
+	}
+	var subjects []string
+	for _, s := range m.Subjects {
+		if s = clean(s); s != "" {
+			subjects = append(subjects, s)
+		}
+	}
+	b.Genre = strings.Join(subjects, ", ")
+	for _, id := range m.Identifiers {
+		if isbn := isbn(id); isbn != "" {
+			b.ISBN = isbn
+			break
+		}
+	}
+	return b
+}
+
+//isbn returns the ISBN of an identifier, or "" if the identifier is something else (a Calibre or publisher UUID, a DOI, ...)
+func isbn(id identifier) string {
+	v := clean(id.Value)
+	lv := strings.ToLower(v)
+	switch {
+	case strings.HasPrefix(lv, "urn:isbn:"):
+		v = v[len("urn:isbn:"):]
+	case strings.HasPrefix(lv, "isbn:"):
+		v = v[len("isbn:"):]
+	case strings.EqualFold(id.Scheme, "isbn"):
+	default:
+		//Identifiers without a scheme are only accepted if they look like an ISBN
+		if !isbnPattern.MatchString(strings.ReplaceAll(v, "-", "")) {
+			return ""
+		}
+	}
+	return strings.ReplaceAll(strings.TrimSpace(v), "-", "")
+}
+
+//clean collapses the whitespace of xml character data
+func clean(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
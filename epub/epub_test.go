@@ -0,0 +1,142 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+const containerXML = `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>`
+
+//epub3 has EPUB 3 metadata, with roles refining the creators
+const epub3 = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="uid">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="uid">urn:uuid:a7c4e0d2-4a5b-4c1e-9d6f-2b8e5f3a1c90</dc:identifier>
+    <dc:identifier>urn:isbn:978-0-15-144647-6</dc:identifier>
+    <dc:title>
+      The Name of
+      the Rose
+    </dc:title>
+    <dc:creator id="c1">Umberto Eco</dc:creator>
+    <meta refines="#c1" property="role" scheme="marc:relators">aut</meta>
+    <dc:creator id="c2">William Weaver</dc:creator>
+    <meta refines="#c2" property="role" scheme="marc:relators">trl</meta>
+    <dc:date>1983-06-01</dc:date>
+    <dc:subject>Fiction</dc:subject>
+    <dc:subject>Mystery</dc:subject>
+  </metadata>
+</package>`
+
+//calibreOPF is a Calibre metadata.opf with EPUB 2 metadata and the date Calibre writes for books without one
+const calibreOPF = `<?xml version='1.0' encoding='utf-8'?>
+<package xmlns="http://www.idpf.org/2007/opf" unique-identifier="uuid_id" version="2.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+    <dc:identifier opf:scheme="calibre" id="calibre_id">42</dc:identifier>
+    <dc:identifier opf:scheme="uuid" id="uuid_id">3f1b7c9e-2d4a-4e8b-b6c5-9a0f1e2d3c4b</dc:identifier>
+    <dc:identifier opf:scheme="ISBN">0-15-144647-4</dc:identifier>
+    <dc:title>Foucault's Pendulum</dc:title>
+    <dc:creator opf:role="aut" opf:file-as="Eco, Umberto">Umberto Eco</dc:creator>
+    <dc:creator opf:role="edt">Some Editor</dc:creator>
+    <dc:creator>Another Author</dc:creator>
+    <dc:date>0101-01-01T00:00:00+00:00</dc:date>
+    <dc:date>1988-10-01</dc:date>
+  </metadata>
+</package>`
+
+//zipFiles returns a zip archive of the files, keyed by name
+func zipFiles(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	z := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := z.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRead(t *testing.T) {
+	data := zipFiles(t, map[string]string{"mimetype": "application/epub+zip", "META-INF/container.xml": containerXML, "OEBPS/content.opf": epub3})
+	b, err := Read(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := finisafricae.Book{Title: "The Name of the Rose", Author: "Umberto Eco", Year: "1983", Genre: "Fiction, Mystery", ISBN: "9780151446476"}
+	if *b != want {
+		t.Errorf("got %+v, want %+v", *b, want)
+	}
+}
+
+func TestReadOPF(t *testing.T) {
+	b, err := ReadOPF(strings.NewReader(calibreOPF))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := finisafricae.Book{Title: "Foucault's Pendulum", Author: "Umberto Eco & Another Author", Year: "1988", ISBN: "0151446474"}
+	if *b != want {
+		t.Errorf("got %+v, want %+v", *b, want)
+	}
+}
+
+func TestReadOPFYear(t *testing.T) {
+	for date, want := range map[string]string{
+		"2001":                      "2001",
+		"1999-12-31T23:00:00Z":      "1999",
+		"0101-01-01T00:00:00+00:00": "",
+		"1399":                      "",
+		"1400":                      "1400",
+		"unknown":                   "",
+	} {
+		opf := `<package><metadata><date>` + date + `</date></metadata></package>`
+		b, err := ReadOPF(strings.NewReader(opf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.Year != want {
+			t.Errorf("date %s: got year %q, want %q", date, b.Year, want)
+		}
+	}
+}
+
+func TestReadMalformed(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		files map[string]string
+		err   error
+	}{
+		{"no container", map[string]string{"OEBPS/content.opf": epub3}, nil},
+		{"invalid container", map[string]string{"META-INF/container.xml": "<container><rootfiles>"}, nil},
+		{"no rootfile", map[string]string{"META-INF/container.xml": "<container><rootfiles/></container>"}, ErrNoPackage},
+		{"missing package document", map[string]string{"META-INF/container.xml": containerXML}, nil},
+		{"invalid package document", map[string]string{"META-INF/container.xml": containerXML, "OEBPS/content.opf": "<package><metadata>"}, nil},
+		{"oversized package document", map[string]string{"META-INF/container.xml": containerXML,
+			"OEBPS/content.opf": "<package><metadata><description>" + strings.Repeat("x", maxXMLSize) + "</description></metadata></package>"}, nil},
+	} {
+		data := zipFiles(t, c.files)
+		_, err := Read(bytes.NewReader(data), int64(len(data)))
+		if err == nil || c.err != nil && !errors.Is(err, c.err) {
+			t.Errorf("%s: got %v, want an error", c.name, err)
+		}
+	}
+	if _, err := Read(strings.NewReader("not a zip"), 9); err == nil {
+		t.Error("not a zip: got no error")
+	}
+}
@@ -0,0 +1,61 @@
+package finisafricae
+
+import (
+	"errors"
+	"fmt"
+)
+
+//Kinds of domain errors. Services return them, usually wrapped in an *Error with a message for the user,
+//and callers test for them with errors.Is.
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrInvalid      = errors.New("invalid")
+	ErrRateLimited  = errors.New("rate limited")
+)
+
+//Error is a domain error of a given kind, with a message that is safe to show to users
+type Error struct {
+	Kind    error
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+//Unwrap returns the kind of the error, so errors.Is(err, ErrNotFound) works on wrapped errors
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+//Errorf returns an *Error of the given kind with a formatted message
+func Errorf(kind error, format string, a ...interface{}) error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, a...)}
+}
+
+//ErrorMessage returns the message of a domain error. Other errors, which may expose internals such as
+//database details, get a generic message.
+func ErrorMessage(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Message
+	}
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return "Not found."
+	case errors.Is(err, ErrConflict):
+		return "Conflict."
+	case errors.Is(err, ErrUnauthorized):
+		return "Unauthorized."
+	case errors.Is(err, ErrForbidden):
+		return "Forbidden."
+	case errors.Is(err, ErrInvalid):
+		return "Invalid request."
+	case errors.Is(err, ErrRateLimited):
+		return "Too many requests. Try again later."
+	}
+	return "Something went wrong. Try again later."
+}
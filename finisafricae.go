@@ -1,11 +1,102 @@
 //Package finisafricae defines the simple datatypes of the application
 package finisafricae
 
+import (
+	"net/mail"
+	"time"
+	"unicode/utf8"
+)
+
 type User struct {
-	ID       string
+	ID string
+	//Uname is the username, unique regardless of case among the current and former usernames of other users
 	Uname    string
 	Email    string
 	Password string
+	//Verified is true once the user has followed the link mailed to Email
+	Verified bool
+	//TOTPSecret is the secret of the authenticator app of the user, who must enter a code from it to login. It is empty if
+	//two-factor authentication is off. TOTPStep is the time step of the last accepted code, which can't be used again.
+	TOTPSecret string
+	TOTPStep   int64
+	//DeleteAt is when the user asked for their account to be deleted, after a grace period. It is the zero time otherwise.
+	DeleteAt time.Time
+	//Role is what the user may do, one of RoleGuest, RoleMember and RoleAdmin
+	Role string
+	//Disabled users can't login
+	Disabled bool
+}
+
+//Roles of users. Guests can only read their library, members can change it too and admins can manage other users.
+const (
+	RoleGuest  = "guest"
+	RoleMember = "member"
+	RoleAdmin  = "admin"
+)
+
+//Roles are the roles users can have, from the one allowing the least to the one allowing the most
+var Roles = []string{RoleGuest, RoleMember, RoleAdmin}
+
+//HasRole returns true if u has the role or one allowing more
+func (u *User) HasRole(role string) bool {
+	return roleRank(u.Role) >= roleRank(role) && roleRank(u.Role) > 0
+}
+
+//roleRank returns the position of role in Roles counting from one, or zero if it is not a role
+func roleRank(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+//Validate returns an ErrInvalid error if a required field is empty or the email or role is not valid
+func (u *User) Validate() error {
+	if u.Email == "" || u.Uname == "" || u.Password == "" {
+		return Errorf(ErrInvalid, "Email, username and password are required.")
+	}
+	if roleRank(u.Role) == 0 {
+		return Errorf(ErrInvalid, "%q is not a role.", u.Role)
+	}
+	return ValidateEmail(u.Email)
+}
+
+//Limits of the length of usernames
+const (
+	UsernameMinLength = 3
+	UsernameMaxLength = 32
+)
+
+//ValidateUsername returns an ErrInvalid error unless uname is between UsernameMinLength and UsernameMaxLength
+//letters, digits, dots, dashes and underscores of ASCII, starting with a letter or digit. Usernames chosen before
+//the rules were made are not checked against them.
+func ValidateUsername(uname string) error {
+	if len(uname) < UsernameMinLength || len(uname) > UsernameMaxLength {
+		return Errorf(ErrInvalid, "Usernames must be between %d and %d characters.", UsernameMinLength, UsernameMaxLength)
+	}
+	for i, c := range uname {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case (c == '.' || c == '-' || c == '_') && i > 0:
+		default:
+			return Errorf(ErrInvalid, "Usernames can only have letters, digits, dots, dashes and underscores, and must start with a letter or digit.")
+		}
+	}
+	return nil
+}
+
+//EmailMaxLength is the most bytes an email may have
+const EmailMaxLength = 255
+
+//ValidateEmail returns an ErrInvalid error unless email is a plain address such as reader@example.com
+func ValidateEmail(email string) error {
+	a, err := mail.ParseAddress(email)
+	if err != nil || a.Address != email || len(email) > EmailMaxLength {
+		return Errorf(ErrInvalid, "%q is not a valid email address.", email)
+	}
+	return nil
 }
 
 type UserService interface {
@@ -13,8 +104,22 @@ type UserService interface {
 	Users() ([]*User, error)
 	CreateUser(u *User) error
 	UpdateUser(u *User) error
+	//DeleteUser deletes the user and everything belonging to them
 	DeleteUser(id string) error
 	UserFromEmail(email string) (*User, error)
+	//UserFromUsername returns the user who has the username, or had it before, regardless of case
+	UserFromUsername(uname string) (*User, error)
+	//UsernameHistory returns the former usernames of the user, newest first
+	UsernameHistory(userID string) ([]*UsernameChange, error)
+	//DeleteScheduled deletes the users whose DeleteAt is before the given time and returns how many were deleted
+	DeleteScheduled(before time.Time) (int64, error)
+}
+
+//UsernameChange records that a user changed their username away from Uname
+type UsernameChange struct {
+	UserID  string
+	Uname   string
+	Changed time.Time
 }
 
 type Book struct {
@@ -25,6 +130,39 @@ type Book struct {
 	Year   string
 	Genre  string
 	Notes  string
+	ISBN   string
+}
+
+//Limits of the length of the fields of books, in characters. Notes are kept short enough to fit a MARC 21 field
+//when exported, whatever characters they hold.
+const (
+	BookFieldMaxLength = 255
+	BookCodeMaxLength  = 32
+	BookNotesMaxLength = 2000
+)
+
+//Validate returns an ErrInvalid error if the book has no title or a field is too long. The year and ISBN may be
+//BookCodeMaxLength characters long, the notes BookNotesMaxLength and the other fields BookFieldMaxLength.
+func (b *Book) Validate() error {
+	if b.Title == "" {
+		return Errorf(ErrInvalid, "The book must have a title.")
+	}
+	for _, f := range []struct {
+		name, value string
+		max         int
+	}{
+		{"title", b.Title, BookFieldMaxLength},
+		{"author", b.Author, BookFieldMaxLength},
+		{"genre", b.Genre, BookFieldMaxLength},
+		{"year", b.Year, BookCodeMaxLength},
+		{"ISBN", b.ISBN, BookCodeMaxLength},
+		{"notes", b.Notes, BookNotesMaxLength},
+	} {
+		if utf8.RuneCountInString(f.value) > f.max {
+			return Errorf(ErrInvalid, "The %s of the book must be at most %d characters.", f.name, f.max)
+		}
+	}
+	return nil
 }
 
 type BookService interface {
@@ -36,9 +174,14 @@ type BookService interface {
 }
 
 type Session struct {
-	ID     string
-	UserID string
-	Time   string
+	ID        string
+	UserID    string
+	Created   time.Time
+	LastSeen  time.Time
+	IP        string
+	UserAgent string
+	//Impersonator is the id of the admin acting as the user in the session for support, or empty
+	Impersonator string
 }
 
 //shared type
@@ -46,7 +189,271 @@ type Session struct {
 type SessionService interface {
 	Session(id string) (*Session, error)
 	Sessions() ([]*Session, error)
+	SessionsForUser(userID string) ([]*Session, error)
 	CreateSession(s *Session) error
 	UpdateSession(s *Session) error
 	DeleteSession(id string) error
+	//DeleteExpired deletes the sessions last seen before the given time and returns how many were deleted
+	DeleteExpired(before time.Time) (int64, error)
+}
+
+//PersistentToken is the long lived token of a "remember me" login. Its cookie holds the Selector, used to look it up,
+//and a random validator of which only the SHA-256 Hash is stored, so a leaked database doesn't let anyone log in.
+type PersistentToken struct {
+	Selector string
+	UserID   string
+	Hash     string
+	Expires  time.Time
+}
+
+type PersistentTokenService interface {
+	PersistentToken(selector string) (*PersistentToken, error)
+	CreatePersistentToken(t *PersistentToken) error
+	DeletePersistentToken(selector string) error
+	DeletePersistentTokensForUser(userID string) error
+	//DeleteExpired deletes the tokens that expired before the given time and returns how many were deleted
+	DeleteExpired(before time.Time) (int64, error)
+}
+
+//OneTimeToken is a secret sent to a user, such as in a password reset link, that can be used once for Purpose before it Expires.
+//Only the SHA-256 Hash of the secret is stored.
+type OneTimeToken struct {
+	Hash    string
+	UserID  string
+	Purpose string
+	Expires time.Time
+	//Data is what the token confirms, such as the new address of an email change
+	Data string
+}
+
+//Purposes of one time tokens
+const (
+	PurposePasswordReset = "password_reset"
+	//PurposeVerifyEmail tokens confirm the email a user signed up with
+	PurposeVerifyEmail = "verify_email"
+	//PurposeChangeEmail tokens confirm a new email of a user, which is their Data. Having their own purpose, a link for a new
+	//email doesn't replace the link confirming the current one.
+	PurposeChangeEmail = "change_email"
+	//PurposeRecoveryCode tokens are the codes that replace a two-factor authentication code once, when the authenticator is lost
+	PurposeRecoveryCode = "recovery_code"
+	//PurposeTOTPSetup tokens hold the secret of an authenticator app in Data until the user enters a code of the app
+	PurposeTOTPSetup = "totp_setup"
+	//PurposeLogin tokens are held by users who have entered their password and have yet to enter a two-factor authentication code
+	PurposeLogin = "login"
+)
+
+type OneTimeTokenService interface {
+	CreateOneTimeToken(t *OneTimeToken) error
+	//OneTimeToken returns the unexpired token with the given hash and purpose without using it up
+	OneTimeToken(hash, purpose string) (*OneTimeToken, error)
+	//UseOneTimeToken deletes and returns the unexpired token with the given hash and purpose. Of concurrent calls only one succeeds.
+	UseOneTimeToken(hash, purpose string) (*OneTimeToken, error)
+	DeleteOneTimeTokensForUser(userID, purpose string) error
+	//DeleteExpired deletes the tokens that expired before the given time and returns how many were deleted
+	DeleteExpired(before time.Time) (int64, error)
+}
+
+//Mail is a plain text email
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+//Mailer sends mail
+type Mailer interface {
+	Send(m *Mail) error
+}
+
+//LoginAttempt counts the failed logins of a Key, such as an email or a client address, and when logins of the key are
+//allowed again
+type LoginAttempt struct {
+	Key         string
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+type LoginAttemptService interface {
+	//LoginAttempt returns the attempts of the key, with no failures if there are none
+	LoginAttempt(key string) (*LoginAttempt, error)
+	//AddFailure counts a failed login of the key at the time now and returns the attempts with it. The count starts over
+	//if the last failure was before forgetBefore. Concurrent failures are all counted.
+	AddFailure(key string, now, forgetBefore time.Time) (*LoginAttempt, error)
+	//LockLoginAttempt locks the key out until the given time, unless it is locked out for longer already
+	LockLoginAttempt(key string, until time.Time) error
+	DeleteLoginAttempt(key string) error
+	//DeleteExpired deletes the attempts last failed before the given time and returns how many were deleted
+	DeleteExpired(before time.Time) (int64, error)
+}
+
+//APIToken lets scripts act as the user through the JSON API. Only the SHA-256 Hash of the secret token is stored,
+//and the token can do no more than its Scope allows.
+type APIToken struct {
+	ID      string
+	UserID  string
+	Name    string
+	Scope   string
+	Hash    string
+	Created time.Time
+	//LastUsed is the zero time if the token has never been used
+	LastUsed time.Time
+}
+
+//Scopes of API tokens
+const (
+	ScopeRead      = "read"
+	ScopeReadWrite = "read-write"
+)
+
+type APITokenService interface {
+	//APIToken returns the token with the given hash
+	APIToken(hash string) (*APIToken, error)
+	APITokensForUser(userID string) ([]*APIToken, error)
+	CreateAPIToken(t *APIToken) error
+	UpdateAPIToken(t *APIToken) error
+	DeleteAPIToken(id string) error
+	DeleteAPITokensForUser(userID string) error
+}
+
+//Identity links a user to their account at an OpenID Connect provider, which they can then sign in with. The provider
+//identifies the account by Subject, unique for its Issuer.
+type Identity struct {
+	Issuer  string
+	Subject string
+	UserID  string
+	//Email is the email of the account at the provider when it was linked, to help the user tell accounts apart
+	Email   string
+	Created time.Time
+}
+
+type IdentityService interface {
+	Identity(issuer, subject string) (*Identity, error)
+	IdentitiesForUser(userID string) ([]*Identity, error)
+	CreateIdentity(i *Identity) error
+	DeleteIdentity(issuer, subject string) error
+	DeleteIdentitiesForUser(userID string) error
+}
+
+//PublicLibrary publishes the library of a user read only, to anyone without logging in. It is at a link with a random
+//token, of which only the SHA-256 Hash is stored, and on the public profile of the user if OnProfile.
+type PublicLibrary struct {
+	UserID string
+	//Hash is empty if the library has no link, such as when it is only on the profile
+	Hash string
+	//Fields are the fields of books shown besides the title, among BookFields
+	Fields    []string
+	OnProfile bool
+	//Created is when the library was published or got its current link
+	Created time.Time
+}
+
+//Fields of books a public library can show
+const (
+	FieldAuthor = "author"
+	FieldYear   = "year"
+	FieldGenre  = "genre"
+	FieldISBN   = "isbn"
+	FieldNotes  = "notes"
+)
+
+//BookFields are the fields of books a public library can show
+var BookFields = []string{FieldAuthor, FieldYear, FieldGenre, FieldISBN, FieldNotes}
+
+//Shows returns true if the public library shows the field of its books
+func (l *PublicLibrary) Shows(field string) bool {
+	for _, f := range l.Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+//Validate returns an ErrInvalid error if the public library shows a field that isn't one of BookFields
+func (l *PublicLibrary) Validate() error {
+	for _, f := range l.Fields {
+		known := false
+		for _, bf := range BookFields {
+			known = known || f == bf
+		}
+		if !known {
+			return Errorf(ErrInvalid, "%q is not a field of books.", f)
+		}
+	}
+	return nil
+}
+
+type PublicLibraryService interface {
+	//PublicLibrary returns the public library of the user with the given id
+	PublicLibrary(userID string) (*PublicLibrary, error)
+	//PublicLibraryFromHash returns the public library whose link has the token with the given hash
+	PublicLibraryFromHash(hash string) (*PublicLibrary, error)
+	//SavePublicLibrary publishes the library of l.UserID, replacing how it was published before
+	SavePublicLibrary(l *PublicLibrary) error
+	DeletePublicLibrary(userID string) error
+}
+
+//AuditEvent records a security or data changing event, such as a login or a deleted book. Events are only ever added,
+//never changed or deleted.
+type AuditEvent struct {
+	ID   int64
+	Time time.Time
+	//Type is one of the Audit constants
+	Type string
+	//UserID is the user the event is about, or empty if there is none, such as a failed login with an unknown email
+	UserID string
+	//ActorID is the admin who caused the event, if it wasn't the user, or AuditActorCLI
+	ActorID string
+	IP      string
+	//Detail describes the event, such as the title of the book or the email logged in with
+	Detail string
+}
+
+//Types of audit events
+const (
+	AuditLogin          = "login"
+	AuditLoginFailed    = "login-failed"
+	AuditLockout        = "lockout"
+	AuditPasswordChange = "password-change"
+	AuditPasswordReset  = "password-reset"
+	AuditEmailChange    = "email-change"
+	AuditUsernameChange = "username-change"
+	AuditBookCreate     = "book-create"
+	AuditBookUpdate     = "book-update"
+	AuditBookDelete     = "book-delete"
+	AuditShare          = "share"
+	AuditSessionRevoke  = "session-revoke"
+	AuditRoleChange     = "role-change"
+	AuditDisable        = "disable"
+	AuditEnable         = "enable"
+	AuditImpersonate    = "impersonate"
+	AuditUserDelete     = "user-delete"
+)
+
+//AuditTypes are the types of audit events
+var AuditTypes = []string{AuditLogin, AuditLoginFailed, AuditLockout, AuditPasswordChange, AuditPasswordReset, AuditEmailChange, AuditUsernameChange,
+	AuditBookCreate, AuditBookUpdate, AuditBookDelete, AuditShare, AuditSessionRevoke, AuditRoleChange, AuditDisable, AuditEnable, AuditImpersonate,
+	AuditUserDelete}
+
+//AuditActorCLI is the ActorID of events caused by an administrator with the finisafricae command, who is no user
+const AuditActorCLI = "cli"
+
+//AuditFilter selects audit events. Empty fields select events of any user, type or time.
+type AuditFilter struct {
+	UserID string
+	Type   string
+	//From and To select the events in [From, To)
+	From time.Time
+	To   time.Time
+	//Limit is the most events returned, or no limit if zero
+	Limit int
+}
+
+//AuditService keeps the audit log. It has no way to change or delete events.
+type AuditService interface {
+	//RecordAuditEvent adds e to the log and sets its ID
+	RecordAuditEvent(e *AuditEvent) error
+	//AuditEvents returns the events selected by f, newest first
+	AuditEvents(f AuditFilter) ([]*AuditEvent, error)
 }
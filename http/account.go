@@ -0,0 +1,73 @@
+package http
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//DeleteAccountHandler schedules the deletion of the account of the user, who must enter their password. The user is
+//logged out everywhere, and the account is deleted with all books DeleteAfter later, unless the user logs in before then.
+type DeleteAccountHandler struct {
+	UserService finisafricae.UserService
+	Sessions    *SessionManager
+	Passwords   finisafricae.PasswordHasher
+	Mailer      finisafricae.Mailer
+	DeleteAfter time.Duration
+	Templates   Templates
+}
+
+//deleteAccountPage is the data of deleteaccount.gohtml
+type deleteAccountPage struct {
+	Message string
+	//Days is how many days the account is kept before it is deleted
+	Days int
+}
+
+func (h *DeleteAccountHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := finisafricae.UserFromContext(r.Context())
+	p := deleteAccountPage{Days: int(h.DeleteAfter.Hours() / 24)}
+	if r.Method != "POST" {
+		render(w, r, h.Templates, "deleteaccount.gohtml", p)
+		return
+	}
+	if err := h.Passwords.Compare(u.Password, r.FormValue("password")); err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		p.Message = "Wrong password."
+		render(w, r, h.Templates, "deleteaccount.gohtml", p)
+		return
+	}
+	u.DeleteAt = time.Now().Add(h.DeleteAfter).UTC()
+	if err := h.UserService.UpdateUser(u); err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	if err := h.Sessions.EndUser(w, r, u.ID); err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	date := u.DeleteAt.Format("2 January 2006 15:04 MST")
+	m := &finisafricae.Mail{
+		To:      u.Email,
+		Subject: "Your finis Africae account will be deleted",
+		Body: "Your finis Africae account and all the books in it will be deleted on " + date + ".\n\n" +
+			"If you didn't ask for this, or changed your mind, login before then to keep your account, and change your password.\n",
+	}
+	if err := h.Mailer.Send(m); err != nil {
+		log.Printf("mailing account deletion notice: %v", err)
+	}
+	//The user is logged out, so the page is shown as to a visitor
+	r = r.WithContext(finisafricae.NewContextWithUser(r.Context(), nil))
+	render(w, r, h.Templates, "index.gohtml", "Your account will be deleted on "+date+". Login before then to keep it.")
+}
+
+//keepAccount cancels the deletion of the account of u, who has logged in during the grace period
+func keepAccount(s finisafricae.UserService, u *finisafricae.User) error {
+	if u.DeleteAt.IsZero() {
+		return nil
+	}
+	u.DeleteAt = time.Time{}
+	return s.UpdateUser(u)
+}
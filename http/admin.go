@@ -0,0 +1,206 @@
+package http
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//AdminHandler lets admins manage the other users. GET lists them with their book counts and last activity. POST sets the
+//role of the user with the id of the form, disables or enables them, or forces them to reset their password.
+//Everything done is recorded in the audit log.
+type AdminHandler struct {
+	UserService finisafricae.UserService
+	BookService finisafricae.BookService
+	Sessions    *SessionManager
+	Links       *LinkMailer
+	Passwords   finisafricae.PasswordHasher
+	//ResetFor is how long the link of a forced password reset works
+	ResetFor  time.Duration
+	Audit     *Auditor
+	Templates Templates
+}
+
+//adminUser is a user listed in admin.gohtml
+type adminUser struct {
+	*finisafricae.User
+	Books int
+	//LastSeen is when the user was last active in a session, zero if they have none
+	LastSeen time.Time
+}
+
+//adminPage is the data of admin.gohtml
+type adminPage struct {
+	Users   []adminUser
+	Roles   []string
+	Message string
+}
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		h.render(w, r, "")
+		return
+	}
+	admin := finisafricae.UserFromContext(r.Context())
+	u, err := h.UserService.User(r.FormValue("id"))
+	if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	if u.ID == admin.ID {
+		//Admins can't lock themselves out, so there is always someone left to manage the users
+		w.WriteHeader(http.StatusBadRequest)
+		h.render(w, r, "You can't change your own account here.")
+		return
+	}
+	var m string
+	switch action := r.FormValue("action"); action {
+	case "role":
+		old := u.Role
+		u.Role = r.FormValue("role")
+		if err := u.Validate(); err != nil {
+			renderError(w, r, h.Templates, err)
+			return
+		}
+		if err := h.UserService.UpdateUser(u); err != nil {
+			renderError(w, r, h.Templates, err)
+			return
+		}
+		h.Audit.Record(r, &finisafricae.AuditEvent{Type: finisafricae.AuditRoleChange, UserID: u.ID, ActorID: admin.ID, Detail: old + " to " + u.Role})
+		m = u.Email + " is now " + u.Role + "."
+	case "disable", "enable":
+		u.Disabled = action == "disable"
+		if err := h.UserService.UpdateUser(u); err != nil {
+			renderError(w, r, h.Templates, err)
+			return
+		}
+		if u.Disabled {
+			//The user is logged out everywhere, and their remembered logins forgotten
+			if err := h.Sessions.LogoutUser(u.ID); err != nil {
+				renderError(w, r, h.Templates, err)
+				return
+			}
+		}
+		typ := finisafricae.AuditEnable
+		if u.Disabled {
+			typ = finisafricae.AuditDisable
+		}
+		h.Audit.Record(r, &finisafricae.AuditEvent{Type: typ, UserID: u.ID, ActorID: admin.ID})
+		m = u.Email + " is " + action + "d."
+	case "reset":
+		if err := h.forceReset(u); err != nil {
+			renderError(w, r, h.Templates, err)
+			return
+		}
+		h.Audit.Record(r, &finisafricae.AuditEvent{Type: finisafricae.AuditPasswordReset, UserID: u.ID, ActorID: admin.ID, Detail: "Forced by an admin"})
+		m = u.Email + " has been logged out and mailed a link to choose a new password."
+	default:
+		renderError(w, r, h.Templates, finisafricae.Errorf(finisafricae.ErrInvalid, "Unknown action %q.", action))
+		return
+	}
+	h.render(w, r, m)
+}
+
+//forceReset replaces the password of u with one nobody knows, logs them out everywhere and mails them a link to choose a new one
+func (h *AdminHandler) forceReset(u *finisafricae.User) error {
+	p, err := newToken()
+	if err != nil {
+		return err
+	}
+	if u.Password, err = h.Passwords.Hash(p); err != nil {
+		return err
+	}
+	if err := h.UserService.UpdateUser(u); err != nil {
+		return err
+	}
+	if err := h.Sessions.LogoutUser(u.ID); err != nil {
+		return err
+	}
+	reason := "An administrator has reset your finis Africae password."
+	return sendPasswordReset(h.Links, u, h.ResetFor, reason, "Until you do, you can't login with a password.")
+}
+
+//render renders the list of users with the message m
+func (h *AdminHandler) render(w http.ResponseWriter, r *http.Request, m string) {
+	us, err := h.UserService.Users()
+	if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	sort.Slice(us, func(i, j int) bool { return strings.ToLower(us[i].Email) < strings.ToLower(us[j].Email) })
+	aus := make([]adminUser, len(us))
+	for i, u := range us {
+		bs, err := h.BookService.Books(u.ID)
+		if err != nil {
+			renderError(w, r, h.Templates, err)
+			return
+		}
+		ses, err := h.Sessions.SessionService.SessionsForUser(u.ID)
+		if err != nil {
+			renderError(w, r, h.Templates, err)
+			return
+		}
+		aus[i] = adminUser{User: u, Books: len(bs)}
+		for _, s := range ses {
+			//Admins acting as the user are not the user being active
+			if s.Impersonator == "" && s.LastSeen.After(aus[i].LastSeen) {
+				aus[i].LastSeen = s.LastSeen
+			}
+		}
+	}
+	render(w, r, h.Templates, "admin.gohtml", adminPage{Users: aus, Roles: finisafricae.Roles, Message: m})
+}
+
+//ImpersonateHandler lets an admin act as another user for support. POST with action "start" replaces the session of the admin
+//with one of the user with the id of the form, and "stop" returns to the session of the admin. Pages show a banner meanwhile.
+type ImpersonateHandler struct {
+	UserService finisafricae.UserService
+	Sessions    *SessionManager
+	Audit       *Auditor
+	Templates   Templates
+}
+
+func (h *ImpersonateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return
+	}
+	u := finisafricae.UserFromContext(r.Context())
+	s := finisafricae.SessionFromContext(r.Context())
+	admin := finisafricae.ImpersonatorFromContext(r.Context())
+	if r.FormValue("action") == "stop" {
+		if admin == nil {
+			http.Redirect(w, r, "/home", http.StatusSeeOther)
+			return
+		}
+		if err := h.Sessions.StopImpersonating(w, r, s); err != nil {
+			renderError(w, r, h.Templates, err)
+			return
+		}
+		h.Audit.Record(r, &finisafricae.AuditEvent{Type: finisafricae.AuditImpersonate, UserID: u.ID, ActorID: admin.ID, Detail: "Stopped"})
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return
+	}
+	if admin != nil || !u.HasRole(finisafricae.RoleAdmin) {
+		renderError(w, r, h.Templates, finisafricae.Errorf(finisafricae.ErrForbidden, "You are not allowed to do this."))
+		return
+	}
+	target, err := h.UserService.User(r.FormValue("id"))
+	if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	if target.ID == u.ID {
+		renderError(w, r, h.Templates, finisafricae.Errorf(finisafricae.ErrInvalid, "You can't act as yourself."))
+		return
+	}
+	if err := h.Sessions.Impersonate(w, r, s, target.ID); err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	h.Audit.Record(r, &finisafricae.AuditEvent{Type: finisafricae.AuditImpersonate, UserID: target.ID, ActorID: u.ID, Detail: "Started"})
+	http.Redirect(w, r, "/home", http.StatusSeeOther)
+}
@@ -0,0 +1,143 @@
+package http
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/madskrogh/finisafricae"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+//apiBook is the JSON representation of a book in the API
+type apiBook struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Author string `json:"author"`
+	Year   string `json:"year"`
+	Genre  string `json:"genre"`
+	Notes  string `json:"notes"`
+	ISBN   string `json:"isbn"`
+}
+
+func newAPIBook(b *finisafricae.Book) apiBook {
+	return apiBook{ID: b.ID, Title: b.Title, Author: b.Author, Year: b.Year, Genre: b.Genre, Notes: b.Notes, ISBN: b.ISBN}
+}
+
+//BooksAPIHandler serves the books of the user as JSON. GET /api/books lists them and POST creates one. GET, PUT and
+//DELETE of /api/books/{id} get, replace and delete a single book. Guests can only read.
+type BooksAPIHandler struct {
+	BookService finisafricae.BookService
+	Audit       *Auditor
+}
+
+func (h *BooksAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := finisafricae.UserFromContext(r.Context())
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS":
+	default:
+		if !u.HasRole(finisafricae.RoleMember) {
+			renderError(w, r, nil, finisafricae.Errorf(finisafricae.ErrForbidden, "Guests can't change the library."))
+			return
+		}
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/books"), "/")
+	if id == "" {
+		switch r.Method {
+		case "GET", "HEAD":
+			bs, err := h.BookService.Books(u.ID)
+			if err != nil {
+				renderError(w, r, nil, err)
+				return
+			}
+			abs := make([]apiBook, len(bs))
+			for i, b := range bs {
+				abs[i] = newAPIBook(b)
+			}
+			writeJSON(w, http.StatusOK, abs)
+		case "POST":
+			bID, err := uuid.NewV4()
+			if err != nil {
+				renderError(w, r, nil, err)
+				return
+			}
+			b := &finisafricae.Book{ID: bID.String(), UserID: u.ID}
+			if err := readAPIBook(w, r, b); err != nil {
+				renderError(w, r, nil, err)
+				return
+			}
+			if err := h.BookService.CreateBook(b); err != nil {
+				renderError(w, r, nil, err)
+				return
+			}
+			h.Audit.Record(r, &finisafricae.AuditEvent{Type: finisafricae.AuditBookCreate, UserID: u.ID, Detail: b.Title})
+			w.Header().Set("Location", "/api/books/"+b.ID)
+			writeJSON(w, http.StatusCreated, newAPIBook(b))
+		default:
+			methodNotAllowed(w, "GET, HEAD, POST")
+		}
+		return
+	}
+
+	b, err := h.BookService.Book(id)
+	if err == nil && b.UserID != u.ID {
+		//The books of other users are not revealed to exist
+		err = finisafricae.Errorf(finisafricae.ErrNotFound, "The book was not found.")
+	}
+	if err != nil {
+		renderError(w, r, nil, err)
+		return
+	}
+	switch r.Method {
+	case "GET", "HEAD":
+		writeJSON(w, http.StatusOK, newAPIBook(b))
+	case "PUT":
+		if err := readAPIBook(w, r, b); err != nil {
+			renderError(w, r, nil, err)
+			return
+		}
+		if err := h.BookService.UpdateBook(b); err != nil {
+			renderError(w, r, nil, err)
+			return
+		}
+		h.Audit.Record(r, &finisafricae.AuditEvent{Type: finisafricae.AuditBookUpdate, UserID: u.ID, Detail: b.Title})
+		writeJSON(w, http.StatusOK, newAPIBook(b))
+	case "DELETE":
+		if err := h.BookService.DeleteBook(b.ID); err != nil {
+			renderError(w, r, nil, err)
+			return
+		}
+		h.Audit.Record(r, &finisafricae.AuditEvent{Type: finisafricae.AuditBookDelete, UserID: u.ID, Detail: b.Title})
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, "GET, HEAD, PUT, DELETE")
+	}
+}
+
+//readAPIBook sets the fields of b, other than its ID and owner, from the JSON body of r
+func readAPIBook(w http.ResponseWriter, r *http.Request, b *finisafricae.Book) error {
+	var ab apiBook
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&ab); err != nil {
+		return finisafricae.Errorf(finisafricae.ErrInvalid, "The book is not valid JSON: %v", err)
+	}
+	b.Title, b.Author, b.Year, b.Genre, b.Notes, b.ISBN = ab.Title, ab.Author, ab.Year, ab.Genre, ab.Notes, ab.ISBN
+	return b.Validate()
+}
+
+//writeJSON responds with status and v encoded as JSON
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		//Headers are already sent, so the error can only be logged
+		log.Printf("writing JSON: %v", err)
+	}
+}
+
+//methodNotAllowed responds with a 405 listing the allowed methods
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "The method is not allowed."})
+}
@@ -0,0 +1,127 @@
+package http
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//Auditor records the audit events of requests. A nil Auditor records nothing.
+type Auditor struct {
+	AuditService finisafricae.AuditService
+}
+
+//Record records e as happening now to the client of r. Unless e has an ActorID, the admin acting as the user of r is the actor.
+//An event that can't be recorded doesn't fail the request, it is logged instead.
+func (a *Auditor) Record(r *http.Request, e *finisafricae.AuditEvent) {
+	if a == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	e.IP = clientIP(r)
+	if admin := finisafricae.ImpersonatorFromContext(r.Context()); admin != nil && e.ActorID == "" {
+		e.ActorID = admin.ID
+	}
+	if err := a.AuditService.RecordAuditEvent(e); err != nil {
+		log.Printf("recording %s audit event of user %s: %v", e.Type, e.UserID, err)
+	}
+}
+
+//auditPage is the data of activity.gohtml and audit.gohtml
+type auditPage struct {
+	Events []*finisafricae.AuditEvent
+	//Emails maps the ids of users to their emails, for showing who events are about
+	Emails map[string]string
+	Types  []string
+	//Filter is the form of the query, Message tells what is wrong with it
+	Filter  url.Values
+	Message string
+}
+
+//ActivityHandler lists the most recent audit events about the user, so they can spot logins and changes they didn't make
+type ActivityHandler struct {
+	AuditService finisafricae.AuditService
+	Templates    Templates
+}
+
+//activityEvents is how many events ActivityHandler lists
+const activityEvents = 100
+
+func (h *ActivityHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := finisafricae.UserFromContext(r.Context())
+	es, err := h.AuditService.AuditEvents(finisafricae.AuditFilter{UserID: u.ID, Limit: activityEvents})
+	if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	render(w, r, h.Templates, "activity.gohtml", auditPage{Events: es})
+}
+
+//AuditHandler lets admins query the audit log by the email of the user, the type of event and a range of dates
+type AuditHandler struct {
+	AuditService finisafricae.AuditService
+	UserService  finisafricae.UserService
+	Templates    Templates
+}
+
+//auditEvents is the most events AuditHandler lists
+const auditEvents = 500
+
+//auditDate is the layout of the dates of the query form
+const auditDate = "2006-01-02"
+
+func (h *AuditHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	us, err := h.UserService.Users()
+	if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	p := auditPage{Emails: make(map[string]string, len(us)), Types: finisafricae.AuditTypes, Filter: r.URL.Query()}
+	for _, u := range us {
+		p.Emails[u.ID] = u.Email
+	}
+	p.Emails[finisafricae.AuditActorCLI] = "command line"
+	f, err := h.filter(r)
+	if err != nil {
+		w.WriteHeader(ErrorStatus(err))
+		p.Message = finisafricae.ErrorMessage(err)
+		render(w, r, h.Templates, "audit.gohtml", p)
+		return
+	}
+	if p.Events, err = h.AuditService.AuditEvents(f); err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	render(w, r, h.Templates, "audit.gohtml", p)
+}
+
+//filter returns the filter of the query of r. The to date is included.
+func (h *AuditHandler) filter(r *http.Request) (finisafricae.AuditFilter, error) {
+	f := finisafricae.AuditFilter{Type: r.FormValue("type"), Limit: auditEvents}
+	if email := strings.TrimSpace(r.FormValue("email")); email != "" {
+		u, err := h.UserService.UserFromEmail(email)
+		if err != nil {
+			return f, err
+		}
+		f.UserID = u.ID
+	}
+	if from := r.FormValue("from"); from != "" {
+		t, err := time.Parse(auditDate, from)
+		if err != nil {
+			return f, finisafricae.Errorf(finisafricae.ErrInvalid, "%q is not a date.", from)
+		}
+		f.From = t
+	}
+	if to := r.FormValue("to"); to != "" {
+		t, err := time.Parse(auditDate, to)
+		if err != nil {
+			return f, finisafricae.Errorf(finisafricae.ErrInvalid, "%q is not a date.", to)
+		}
+		f.To = t.AddDate(0, 0, 1)
+	}
+	return f, nil
+}
@@ -0,0 +1,223 @@
+package http
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//Authenticator authenticates every request once, from its session cookie, and stores the session and user in the request context
+//for the handlers and the RequireAuth and RequireGuest wrappers. Requests to the JSON API under /api/ may instead
+//authenticate with an API token in an "Authorization: Bearer" header.
+type Authenticator struct {
+	UserService     finisafricae.UserService
+	Sessions        *SessionManager
+	APITokenService finisafricae.APITokenService
+	//Audit records what admins acting as other users change
+	Audit *Auditor
+}
+
+//errDisabled refuses logins to accounts an admin has disabled
+var errDisabled = finisafricae.Errorf(finisafricae.ErrForbidden, "Your account is disabled.")
+
+//errImpersonating refuses the changes of credentials and security settings an admin acting as a user can't make
+var errImpersonating = finisafricae.Errorf(finisafricae.ErrForbidden, "Only the user can do this, not an admin acting as them.")
+
+//apiTokenUseInterval is how often the last use of an API token is recorded, so busy scripts don't write on every request
+const apiTokenUseInterval = time.Minute
+
+//Authenticate wraps next. Requests without a valid session are passed on without a user in their context.
+func (a *Authenticator) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if bearer, ok := bearerToken(r); ok && strings.HasPrefix(r.URL.Path, "/api/") {
+			a.authenticateAPI(w, r, bearer, next)
+			return
+		}
+		s, u, admin, err := a.authenticate(w, r)
+		if err != nil {
+			//Errors, such as an unreachable database, are logged and treated as not being logged in
+			log.Printf("authenticating: %v", err)
+		}
+		if u != nil {
+			ctx := finisafricae.NewContextWithSession(r.Context(), s)
+			if admin != nil {
+				ctx = finisafricae.NewContextWithImpersonator(ctx, admin)
+				if r.Method != "GET" && r.Method != "HEAD" {
+					a.Audit.Record(r, &finisafricae.AuditEvent{Type: finisafricae.AuditImpersonate, UserID: u.ID, ActorID: admin.ID, Detail: r.Method + " " + r.URL.Path})
+				}
+			}
+			r = r.WithContext(finisafricae.NewContextWithUser(ctx, u))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//authenticate returns the session and user of the session cookie of r, and the admin acting as the user if the session is
+//one of impersonation. They are nil if the user is not logged in. Expired sessions are deleted and their cookie cleared.
+//Without a valid session, a new one is started from the remember cookie if there is one.
+func (a *Authenticator) authenticate(w http.ResponseWriter, r *http.Request) (*finisafricae.Session, *finisafricae.User, *finisafricae.User, error) {
+	s, err := a.session(w, r)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if s == nil {
+		if s, err = a.Sessions.restore(w, r); s == nil || err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	u, err := a.UserService.User(s.UserID)
+	if errors.Is(err, finisafricae.ErrNotFound) {
+		//The user has been deleted
+		return nil, nil, nil, a.Sessions.End(w, s)
+	} else if err != nil {
+		return nil, nil, nil, err
+	}
+	var admin *finisafricae.User
+	if s.Impersonator != "" {
+		//The session lasts only as long as the admin may impersonate
+		admin, err = a.UserService.User(s.Impersonator)
+		if errors.Is(err, finisafricae.ErrNotFound) || err == nil && (admin.Disabled || !admin.HasRole(finisafricae.RoleAdmin)) {
+			return nil, nil, nil, a.Sessions.End(w, s)
+		} else if err != nil {
+			return nil, nil, nil, err
+		}
+	} else if u.Disabled {
+		return nil, nil, nil, a.Sessions.End(w, s)
+	}
+	//Session is valid. Update last seen.
+	s.LastSeen = time.Now().UTC()
+	s.IP = clientIP(r)
+	if err := a.Sessions.SessionService.UpdateSession(s); err != nil {
+		return nil, nil, nil, err
+	}
+	return s, u, admin, nil
+}
+
+//authenticateAPI passes r on to next as the user of the API token bearer. Unknown tokens get a 401, and read
+//only tokens get a 403 for requests that could change anything.
+func (a *Authenticator) authenticateAPI(w http.ResponseWriter, r *http.Request, bearer string, next http.Handler) {
+	t, err := a.APITokenService.APIToken(hashToken(bearer))
+	if errors.Is(err, finisafricae.ErrNotFound) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="finis Africae"`)
+		renderError(w, r, nil, finisafricae.Errorf(finisafricae.ErrUnauthorized, "The API token is not valid."))
+		return
+	} else if err != nil {
+		renderError(w, r, nil, err)
+		return
+	}
+	u, err := a.UserService.User(t.UserID)
+	if err != nil {
+		renderError(w, r, nil, err)
+		return
+	}
+	if u.Disabled {
+		renderError(w, r, nil, finisafricae.Errorf(finisafricae.ErrUnauthorized, "The account is disabled."))
+		return
+	}
+	if !u.DeleteAt.IsZero() {
+		//Only logging in keeps an account being deleted
+		renderError(w, r, nil, finisafricae.Errorf(finisafricae.ErrUnauthorized, "The account is being deleted."))
+		return
+	}
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS":
+	default:
+		if t.Scope != finisafricae.ScopeReadWrite {
+			renderError(w, r, nil, finisafricae.Errorf(finisafricae.ErrForbidden, "The API token is read only."))
+			return
+		}
+	}
+	if now := time.Now().UTC(); now.Sub(t.LastUsed) >= apiTokenUseInterval {
+		t.LastUsed = now
+		if err := a.APITokenService.UpdateAPIToken(t); err != nil {
+			renderError(w, r, nil, err)
+			return
+		}
+	}
+	ctx := finisafricae.NewContextWithAPIToken(r.Context(), t)
+	next.ServeHTTP(w, r.WithContext(finisafricae.NewContextWithUser(ctx, u)))
+}
+
+//bearerToken returns the token of the "Authorization: Bearer" header of r, if it has one
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(h[7:]), true
+}
+
+//session returns the unexpired session of the session cookie of r, or nil if there is none
+func (a *Authenticator) session(w http.ResponseWriter, r *http.Request) (*finisafricae.Session, error) {
+	//Get cookie
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil, nil
+	}
+	//Cookie exists. Get session.
+	s, err := a.Sessions.SessionService.Session(c.Value)
+	if errors.Is(err, finisafricae.ErrNotFound) {
+		a.Sessions.clearCookie(w)
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	//Session exists. Tjek if it is expired.
+	if a.Sessions.Expired(s) {
+		return nil, a.Sessions.End(w, s)
+	}
+	return s, nil
+}
+
+//RequireAuth wraps a handler that requires a logged in user. Other requests are sent to the front page, or get a 401 if they ask for JSON.
+func RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if finisafricae.UserFromContext(r.Context()) == nil {
+			if wantsJSON(r) {
+				renderError(w, r, nil, finisafricae.Errorf(finisafricae.ErrUnauthorized, "You are not logged in."))
+				return
+			}
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//RequireGuest wraps a handler only meant for users who are not logged in, such as login and signup. Logged in users are sent home.
+func RequireGuest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if finisafricae.UserFromContext(r.Context()) != nil {
+			http.Redirect(w, r, "/home", http.StatusSeeOther)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//RequireRole wraps a handler that requires a logged in user with the role or one allowing more. Other users get a 403.
+//It is meant to be wrapped by RequireAuth.
+func RequireRole(t Templates, role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u := finisafricae.UserFromContext(r.Context()); u == nil || !u.HasRole(role) {
+			renderError(w, r, t, finisafricae.Errorf(finisafricae.ErrForbidden, "You are not allowed to do this."))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//RequireSelf wraps a handler of the credentials and security settings of a user, which an admin acting as the user gets a 403 from
+func RequireSelf(t Templates, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if finisafricae.ImpersonatorFromContext(r.Context()) != nil {
+			renderError(w, r, t, errImpersonating)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,71 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//Names of the cookie, form field and header carrying the CSRF token
+const (
+	csrfCookie = "csrf"
+	csrfField  = "csrf_token"
+	csrfHeader = "X-CSRF-Token"
+)
+
+type csrfContextKey struct{}
+
+//CSRF protects next against cross-site request forgery with the double-submit pattern. Every client gets a random token in
+//a cookie, which render adds to the page data for forms to post back in a hidden csrf_token field (or an X-CSRF-Token header).
+//Requests other than GET, HEAD and OPTIONS whose token doesn't match the cookie get a 403 page, unless they are
+//authenticated with an API token. secure restricts the cookie to https, as SessionManager.SecureCookie does the session cookie.
+func CSRF(t Templates, secure bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := ""
+		if c, err := r.Cookie(csrfCookie); err == nil && len(c.Value) == 43 {
+			token = c.Value
+		} else {
+			var err error
+			if token, err = newCSRFToken(w, secure); err != nil {
+				renderError(w, r, t, err)
+				return
+			}
+		}
+		switch {
+		case r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS":
+		case finisafricae.APITokenFromContext(r.Context()) != nil:
+			//Browsers don't send API tokens by themselves, so requests carrying one can't be forged
+		default:
+			sent := r.Header.Get(csrfHeader)
+			if sent == "" {
+				sent = r.FormValue(csrfField)
+			}
+			if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+				renderError(w, r, t, finisafricae.Errorf(finisafricae.ErrForbidden, "The form has expired or was sent from another site. Go back, reload the page and try again."))
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfContextKey{}, token)))
+	})
+}
+
+//newCSRFToken sets the CSRF cookie to a new random token and returns it
+func newCSRFToken(w http.ResponseWriter, secure bool) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{Name: csrfCookie, Value: token, Path: "/", HttpOnly: true, Secure: secure, SameSite: http.SameSiteLaxMode})
+	return token, nil
+}
+
+//csrfToken returns the CSRF token of the request
+func csrfToken(r *http.Request) string {
+	t, _ := r.Context().Value(csrfContextKey{}).(string)
+	return t
+}
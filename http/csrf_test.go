@@ -0,0 +1,114 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//csrfCookieOf returns the CSRF cookie set by the response of rec, or nil
+func csrfCookieOf(rec *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == csrfCookie {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestCSRF(t *testing.T) {
+	tt := testTemplates(t)
+	token := strings.Repeat("a", 43)
+	h := CSRF(tt, true, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if csrfToken(r) == "" {
+			t.Error("no CSRF token in the context")
+		}
+	}))
+	for _, c := range []struct {
+		name   string
+		method string
+		cookie string
+		field  string
+		header string
+		api    bool
+		status int
+	}{
+		{"GET without token", "GET", "", "", "", false, http.StatusOK},
+		{"HEAD without token", "HEAD", "", "", "", false, http.StatusOK},
+		{"POST with token in field", "POST", token, token, "", false, http.StatusOK},
+		{"POST with token in header", "POST", token, "", token, false, http.StatusOK},
+		{"POST without token", "POST", token, "", "", false, http.StatusForbidden},
+		{"POST with another token", "POST", token, strings.Repeat("b", 43), "", false, http.StatusForbidden},
+		{"POST with another token in header", "POST", token, token, strings.Repeat("b", 43), false, http.StatusForbidden},
+		{"POST without cookie", "POST", "", token, "", false, http.StatusForbidden},
+		{"POST with malformed cookie", "POST", "short", "short", "", false, http.StatusForbidden},
+		{"DELETE without token", "DELETE", token, "", "", false, http.StatusForbidden},
+		{"POST with an API token", "POST", "", "", "", true, http.StatusOK},
+	} {
+		req := httptest.NewRequest(c.method, "/books", strings.NewReader(url.Values{csrfField: {c.field}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if c.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: csrfCookie, Value: c.cookie})
+		}
+		if c.header != "" {
+			req.Header.Set(csrfHeader, c.header)
+		}
+		if c.api {
+			req = req.WithContext(finisafricae.NewContextWithAPIToken(req.Context(), &finisafricae.APIToken{ID: "t"}))
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != c.status {
+			t.Errorf("%s: got status %d, want %d", c.name, rec.Code, c.status)
+		}
+		set := csrfCookieOf(rec)
+		if c.cookie == token && set != nil {
+			t.Errorf("%s: the valid token was replaced", c.name)
+		} else if c.cookie != token && (set == nil || len(set.Value) != 43 || !set.Secure || !set.HttpOnly) {
+			t.Errorf("%s: got cookie %v, want a new secure token", c.name, set)
+		}
+	}
+}
+
+func TestCSRFInsecure(t *testing.T) {
+	rec := httptest.NewRecorder()
+	CSRF(testTemplates(t), false, http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if c := csrfCookieOf(rec); c == nil || c.Secure {
+		t.Errorf("got cookie %v, want a token for plain http", c)
+	}
+}
+
+func TestCSRFRotation(t *testing.T) {
+	sm := &SessionManager{SessionService: &memSessions{}, IdleTimeout: time.Hour, AbsoluteTimeout: time.Hour, SecureCookie: true}
+	old := strings.Repeat("a", 43)
+
+	req := httptest.NewRequest("POST", "/login", nil)
+	req.AddCookie(&http.Cookie{Name: csrfCookie, Value: old})
+	rec := httptest.NewRecorder()
+	if err := sm.Login(rec, req, "u1", false); err != nil {
+		t.Fatal(err)
+	}
+	c := csrfCookieOf(rec)
+	if c == nil || c.Value == old || len(c.Value) != 43 || !c.Secure {
+		t.Errorf("login: got cookie %v, want a new secure token", c)
+	}
+
+	ses, err := sm.SessionService.SessionsForUser("u1")
+	if err != nil || len(ses) != 1 {
+		t.Fatalf("got sessions %v, %v", ses, err)
+	}
+	req = httptest.NewRequest("POST", "/logout", nil)
+	req.AddCookie(&http.Cookie{Name: csrfCookie, Value: c.Value})
+	rec = httptest.NewRecorder()
+	if err := sm.Logout(rec, req, ses[0]); err != nil {
+		t.Fatal(err)
+	}
+	if n := csrfCookieOf(rec); n == nil || n.Value == c.Value || len(n.Value) != 43 {
+		t.Errorf("logout: got cookie %v, want a new token", n)
+	}
+}
@@ -0,0 +1,105 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//errorPage is the data of error.gohtml
+type errorPage struct {
+	Status  int
+	Title   string
+	Message string
+}
+
+//ErrorStatus returns the http status code matching the kind of a domain error. Other errors are internal server errors.
+func ErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, finisafricae.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, finisafricae.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, finisafricae.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, finisafricae.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, finisafricae.ErrInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, finisafricae.ErrRateLimited):
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
+
+//renderError responds with the status code of err and its message, as JSON if the client asks for it and as an html error page otherwise.
+//Internal errors are logged, and their details kept from the client.
+func renderError(w http.ResponseWriter, r *http.Request, t Templates, err error) {
+	status := ErrorStatus(err)
+	if status == http.StatusInternalServerError {
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+	}
+	p := errorPage{Status: status, Title: http.StatusText(status), Message: finisafricae.ErrorMessage(err)}
+	if wantsJSON(r) || t == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": p.Message})
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := t.ExecuteTemplate(w, "error.gohtml", p); err != nil {
+		log.Printf("rendering error page: %v", err)
+	}
+}
+
+//wantsJSON returns true if the client prefers a JSON response. Requests to the API always get JSON.
+func wantsJSON(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/") || strings.Contains(r.Header.Get("Accept"), "application/json") || strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+}
+
+//page is what templates are executed with. Data is the handler specific data, the other fields are available on every page.
+type page struct {
+	User      *finisafricae.User
+	CSRFToken string
+	//SignIn is the name of the OpenID Connect provider users can sign in with, if there is one
+	SignIn string
+	//Impersonator is the admin acting as User, shown in a banner on every page
+	Impersonator *finisafricae.User
+	Data         interface{}
+}
+
+//render executes the named template with data wrapped in a page. The response may already be partly written when execution fails,
+//so errors are only logged.
+func render(w http.ResponseWriter, r *http.Request, t Templates, name string, data interface{}) {
+	p := page{
+		User:         finisafricae.UserFromContext(r.Context()),
+		CSRFToken:    csrfToken(r),
+		SignIn:       signInName(r),
+		Impersonator: finisafricae.ImpersonatorFromContext(r.Context()),
+		Data:         data,
+	}
+	if err := t.ExecuteTemplate(w, name, p); err != nil {
+		log.Printf("rendering %s: %v", name, err)
+	}
+}
+
+//Recover responds with an internal server error page if next panics, instead of dropping the connection
+func Recover(t Templates, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if v := recover(); v != nil {
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				renderError(w, r, t, fmt.Errorf("panic: %v", v))
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,236 @@
+package http
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+	"github.com/madskrogh/finisafricae/templates"
+)
+
+//testTemplates returns the built-in templates
+func testTemplates(t *testing.T) Templates {
+	t.Helper()
+	tt, err := ParseTemplates(templates.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tt
+}
+
+//memUsers is a UserService keeping users in memory. Like the MySQL one it refuses emails and usernames of other users.
+type memUsers struct {
+	finisafricae.UserService
+	mu    sync.Mutex
+	users []*finisafricae.User
+}
+
+func (s *memUsers) User(id string) (*finisafricae.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.ID == id {
+			c := *u
+			return &c, nil
+		}
+	}
+	return nil, finisafricae.Errorf(finisafricae.ErrNotFound, "User not found.")
+}
+
+func (s *memUsers) UserFromEmail(email string) (*finisafricae.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.Email == email {
+			c := *u
+			return &c, nil
+		}
+	}
+	return nil, finisafricae.Errorf(finisafricae.ErrNotFound, "User not found.")
+}
+
+func (s *memUsers) CreateUser(u *finisafricae.User) error {
+	if err := u.Validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, o := range s.users {
+		if o.Email == u.Email || strings.EqualFold(o.Uname, u.Uname) {
+			return finisafricae.Errorf(finisafricae.ErrConflict, "The email or username is taken.")
+		}
+	}
+	c := *u
+	s.users = append(s.users, &c)
+	return nil
+}
+
+func (s *memUsers) UpdateUser(u *finisafricae.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, o := range s.users {
+		if o.ID == u.ID {
+			c := *u
+			s.users[i] = &c
+			return nil
+		}
+	}
+	return finisafricae.Errorf(finisafricae.ErrNotFound, "User not found.")
+}
+
+//memIdentities is an IdentityService keeping identities in memory
+type memIdentities struct {
+	finisafricae.IdentityService
+	mu         sync.Mutex
+	identities []*finisafricae.Identity
+}
+
+func (s *memIdentities) Identity(issuer, subject string) (*finisafricae.Identity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, i := range s.identities {
+		if i.Issuer == issuer && i.Subject == subject {
+			return i, nil
+		}
+	}
+	return nil, finisafricae.Errorf(finisafricae.ErrNotFound, "The account is not linked to any user.")
+}
+
+func (s *memIdentities) CreateIdentity(i *finisafricae.Identity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.identities = append(s.identities, i)
+	return nil
+}
+
+//memSessions is a SessionService keeping sessions in memory
+type memSessions struct {
+	finisafricae.SessionService
+	mu       sync.Mutex
+	sessions map[string]*finisafricae.Session
+}
+
+func (s *memSessions) Session(id string) (*finisafricae.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ses, ok := s.sessions[id]; ok {
+		return ses, nil
+	}
+	return nil, finisafricae.Errorf(finisafricae.ErrNotFound, "Session not found.")
+}
+
+func (s *memSessions) CreateSession(ses *finisafricae.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions == nil {
+		s.sessions = make(map[string]*finisafricae.Session)
+	}
+	s.sessions[ses.ID] = ses
+	return nil
+}
+
+func (s *memSessions) UpdateSession(ses *finisafricae.Session) error {
+	return s.CreateSession(ses)
+}
+
+func (s *memSessions) DeleteSession(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *memSessions) SessionsForUser(userID string) ([]*finisafricae.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ss []*finisafricae.Session
+	for _, ses := range s.sessions {
+		if ses.UserID == userID {
+			ss = append(ss, ses)
+		}
+	}
+	return ss, nil
+}
+
+//plainHasher is a PasswordHasher storing passwords as they are, so tests needn't wait for bcrypt
+type plainHasher struct{}
+
+func (plainHasher) Hash(password string) (string, error) { return "plain:" + password, nil }
+
+func (plainHasher) Compare(hash, password string) error {
+	if hash != "plain:"+password {
+		return finisafricae.Errorf(finisafricae.ErrUnauthorized, "Wrong password.")
+	}
+	return nil
+}
+
+func (plainHasher) NeedsRehash(hash string) bool { return false }
+
+//memTokens is a OneTimeTokenService keeping tokens in memory
+type memTokens struct {
+	finisafricae.OneTimeTokenService
+	mu     sync.Mutex
+	tokens []*finisafricae.OneTimeToken
+}
+
+func (s *memTokens) CreateOneTimeToken(t *finisafricae.OneTimeToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = append(s.tokens, t)
+	return nil
+}
+
+func (s *memTokens) OneTimeToken(hash, purpose string) (*finisafricae.OneTimeToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tokens {
+		if t.Hash == hash && t.Purpose == purpose && time.Now().Before(t.Expires) {
+			return t, nil
+		}
+	}
+	return nil, finisafricae.Errorf(finisafricae.ErrNotFound, "The link is invalid or has expired.")
+}
+
+func (s *memTokens) UseOneTimeToken(hash, purpose string) (*finisafricae.OneTimeToken, error) {
+	t, err := s.OneTimeToken(hash, purpose)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, o := range s.tokens {
+		if o == t {
+			s.tokens = append(s.tokens[:i], s.tokens[i+1:]...)
+			break
+		}
+	}
+	return t, nil
+}
+
+func (s *memTokens) DeleteOneTimeTokensForUser(userID, purpose string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var kept []*finisafricae.OneTimeToken
+	for _, t := range s.tokens {
+		if t.UserID != userID || t.Purpose != purpose {
+			kept = append(kept, t)
+		}
+	}
+	s.tokens = kept
+	return nil
+}
+
+//memMailer is a Mailer keeping the mails it sends
+type memMailer struct {
+	mu    sync.Mutex
+	mails []*finisafricae.Mail
+}
+
+func (m *memMailer) Send(mail *finisafricae.Mail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mails = append(m.mails, mail)
+	return nil
+}
@@ -2,251 +2,408 @@
 package http
 
 import (
-	"html/template"
+	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/madskrogh/finisafricae"
-	"github.com/madskrogh/finisafricae/util"
+	"github.com/madskrogh/finisafricae/importer"
+	"github.com/madskrogh/finisafricae/lockout"
+	"github.com/madskrogh/finisafricae/marc"
 
 	uuid "github.com/satori/go.uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type IndexHandler struct {
-	UserService    finisafricae.UserService
-	SessionService finisafricae.SessionService
-	Templates      *template.Template
+	Templates Templates
 }
 
 func (h *IndexHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if isLoggedIn(h.SessionService, h.UserService, r) {
-		http.Redirect(w, r, "/home", http.StatusSeeOther)
-		return
-	}
-	err := h.Templates.ExecuteTemplate(w, "index.gohtml", nil)
-	util.HandleError(err)
-	return
+	render(w, r, h.Templates, "index.gohtml", nil)
 }
 
 type LoginHandler struct {
-	UserService    finisafricae.UserService
-	SessionService finisafricae.SessionService
-	Templates      *template.Template
+	UserService         finisafricae.UserService
+	OneTimeTokenService finisafricae.OneTimeTokenService
+	Sessions            *SessionManager
+	Links               *LinkMailer
+	Lockout             *lockout.Limiter
+	Passwords           finisafricae.PasswordHasher
+	Audit               *Auditor
+	Templates           Templates
+	//VerifyFor is how long the email verification link mailed to unverified users works
+	VerifyFor time.Duration
 }
 
 func (h *LoginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	//Redirects if user is loggedin or method is GET
-	if isLoggedIn(h.SessionService, h.UserService, r) {
-		http.Redirect(w, r, "/home", http.StatusSeeOther)
-		return
-	} else if r.Method == "GET" {
+	//Redirects if method is GET
+	if r.Method == "GET" {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
-	//Parses request form and gets user from db
-	err := r.ParseForm()
-	util.HandleError(err)
-	u, _ := h.UserService.UserFromEmail(r.Form["email"][0])
+	//Gets user from db by email or username. Usernames can't hold an @.
+	login, ip := strings.TrimSpace(r.FormValue("login")), clientIP(r)
+	var u *finisafricae.User
+	var err error
+	if strings.Contains(login, "@") {
+		u, err = h.UserService.UserFromEmail(login)
+	} else {
+		u, err = h.UserService.UserFromUsername(login)
+	}
+	if err != nil && !errors.Is(err, finisafricae.ErrNotFound) {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	//Failures are counted by email however the user logs in, so alternating between email and username gives no more tries
+	email := login
+	if u != nil {
+		email = u.Email
+	}
+	//Refuses logins to accounts and from addresses with too many failed logins
+	if err := h.Lockout.Check(email, ip); errors.Is(err, finisafricae.ErrRateLimited) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		render(w, r, h.Templates, "index.gohtml", finisafricae.ErrorMessage(err))
+		return
+	} else if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
 
 	if u != nil {
 		//Compares hashed password from form with stored password
-		err = bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(r.Form["password"][0]))
+		err = h.Passwords.Compare(u.Password, r.FormValue("password"))
+		if err == nil && h.Passwords.NeedsRehash(u.Password) {
+			//The password is known now, so its hash is brought up to the current cost or algorithm
+			if err := rehash(h.UserService, h.Passwords, u, r.FormValue("password")); err != nil {
+				log.Printf("rehashing password: %v", err)
+			}
+		}
+		if err == nil && !u.Verified {
+			//Passwords match, but the email is not confirmed. The link may be lost or expired, so a new one is sent.
+			if err := sendVerification(h.Links, u, u.Email, h.VerifyFor); err != nil {
+				renderError(w, r, h.Templates, err)
+				return
+			}
+			w.WriteHeader(http.StatusForbidden)
+			render(w, r, h.Templates, "index.gohtml", "Confirm your email before logging in. A new link has been mailed to "+u.Email+".")
+			return
+		}
+		if err == nil && u.Disabled {
+			//Passwords match, but an admin has disabled the account
+			h.Audit.Record(r, &finisafricae.AuditEvent{Type: finisafricae.AuditLoginFailed, UserID: u.ID, Detail: "The account is disabled."})
+			w.WriteHeader(http.StatusForbidden)
+			render(w, r, h.Templates, "index.gohtml", finisafricae.ErrorMessage(errDisabled))
+			return
+		}
+		if err == nil && u.TOTPSecret != "" {
+			//Passwords match. The user must enter a code from their authenticator app too.
+			startTOTPLogin(w, r, h.Templates, h.OneTimeTokenService, u, r.FormValue("remember") != "")
+			return
+		}
 		if err == nil {
-			//Passwords match. Create new session and cookie for the user.
-			sID, _ := uuid.NewV4()
-			c := &http.Cookie{
-				Name:  "session",
-				Value: sID.String(),
+			//Passwords match. Failures are forgotten only now, as the second step of two-factor authentication can fail too.
+			if err := h.Lockout.Succeed(email); err != nil {
+				renderError(w, r, h.Templates, err)
+				return
+			}
+			//Logging in keeps an account the user asked to delete
+			if err := keepAccount(h.UserService, u); err != nil {
+				renderError(w, r, h.Templates, err)
+				return
+			}
+			//Create new session and cookie for the user, who may have asked to stay logged in after the session ends.
+			if err := h.Sessions.Login(w, r, u.ID, r.FormValue("remember") != ""); err != nil {
+				renderError(w, r, h.Templates, err)
+				return
 			}
-			http.SetCookie(w, c)
-			t := time.Now().Format(time.RFC1123)
-			s := finisafricae.Session{ID: sID.String(), UserID: u.ID, Time: t}
-			err = h.SessionService.CreateSession(&s)
-			util.HandleError(err)
+			h.Audit.Record(r, &finisafricae.AuditEvent{Type: finisafricae.AuditLogin, UserID: u.ID, Detail: "Password"})
 			http.Redirect(w, r, "/home", http.StatusSeeOther)
 			return
 		}
 	}
-	err = h.Templates.ExecuteTemplate(w, "index.gohtml", "Wrong email or password.")
-	util.HandleError(err)
-	return
+	//Failures with unknown emails are recorded too, as they may be someone guessing who has an account
+	e := &finisafricae.AuditEvent{Type: finisafricae.AuditLoginFailed, Detail: "Wrong password for " + login}
+	if u != nil {
+		e.UserID = u.ID
+	}
+	h.Audit.Record(r, e)
+	if err := h.Lockout.Fail(email, ip); err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+	render(w, r, h.Templates, "index.gohtml", "Wrong email, username or password.")
 }
 
 type LogoutHandler struct {
-	UserService    finisafricae.UserService
-	SessionService finisafricae.SessionService
+	Sessions  *SessionManager
+	Templates Templates
 }
 
 func (h *LogoutHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if !isLoggedIn(h.SessionService, h.UserService, r) {
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+	//Logging out changes state, so it is only done on POST
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/home", http.StatusSeeOther)
+		return
+	}
+	if err := h.Sessions.Logout(w, r, finisafricae.SessionFromContext(r.Context())); err != nil {
+		renderError(w, r, h.Templates, err)
 		return
 	}
-	//Gets cookie and deletes session
-	c, err := r.Cookie("session")
-	util.HandleError(err)
-	c.MaxAge = -1
-	h.SessionService.DeleteSession(c.Value)
 	http.Redirect(w, r, "/", http.StatusSeeOther)
-	return
 }
 
 type SignupHandler struct {
-	UserService    finisafricae.UserService
-	SessionService finisafricae.SessionService
-	Templates      *template.Template
+	UserService finisafricae.UserService
+	Links       *LinkMailer
+	Policy      *finisafricae.PasswordPolicy
+	Passwords   finisafricae.PasswordHasher
+	Templates   Templates
+	//VerifyFor is how long the email verification link works
+	VerifyFor time.Duration
 }
 
 func (h *SignupHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if isLoggedIn(h.SessionService, h.UserService, r) {
-		http.Redirect(w, r, "/home", http.StatusSeeOther)
-		return
-	} else if r.Method == "GET" {
+	if r.Method == "GET" {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
-	err := r.ParseForm()
-	if r.Form["password"][0] == "" || r.Form["password2"][0] == "" || r.Form["email"][0] == "" || r.Form["uname"][0] == "" {
+	if r.FormValue("password") == "" || r.FormValue("password2") == "" || r.FormValue("email") == "" || r.FormValue("uname") == "" {
 		//One or more required form fields are empty. User is sent back.
-		err := h.Templates.ExecuteTemplate(w, "index.gohtml", "One or more fields are empty. Try again.")
-		util.HandleError(err)
+		w.WriteHeader(http.StatusBadRequest)
+		render(w, r, h.Templates, "index.gohtml", "One or more fields are empty. Try again.")
 		return
-	} else if u, _ := h.UserService.UserFromEmail(r.Form["email"][0]); u != nil {
-		//A user with the given email is already present in the db. User is sent back.
-		err := h.Templates.ExecuteTemplate(w, "index.gohtml", "A user with this email already exist.")
-		util.HandleError(err)
-		return
-	} else if r.Form["password"][0] != r.Form["password2"][0] {
+	} else if r.FormValue("password") != r.FormValue("password2") {
 		//The password and repeated password doesn't match. User is sent back.
-		err := h.Templates.ExecuteTemplate(w, "index.gohtml", "Passwords does not match. Try again.")
-		util.HandleError(err)
+		w.WriteHeader(http.StatusBadRequest)
+		render(w, r, h.Templates, "index.gohtml", "Passwords does not match. Try again.")
+		return
+	}
+	if err := finisafricae.ValidateUsername(r.FormValue("uname")); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render(w, r, h.Templates, "index.gohtml", finisafricae.ErrorMessage(err))
 		return
 	}
 	//Form is correctly filled. User created and stored. Redirects to login page.
 	uID, err := uuid.NewV4()
-	uP, err := bcrypt.GenerateFromPassword([]byte(r.Form["password"][0]), bcrypt.DefaultCost)
+	if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
 	u := finisafricae.User{
-		ID:       uID.String(),
-		Uname:    r.Form["uname"][0],
-		Email:    r.Form["email"][0],
-		Password: string(uP),
+		ID:    uID.String(),
+		Uname: r.FormValue("uname"),
+		Email: r.FormValue("email"),
+		Role:  finisafricae.RoleMember,
+	}
+	if err := h.Policy.Check(r.FormValue("password"), &u); errors.Is(err, finisafricae.ErrInvalid) {
+		//The password is too weak. User is sent back.
+		w.WriteHeader(http.StatusBadRequest)
+		render(w, r, h.Templates, "index.gohtml", finisafricae.ErrorMessage(err))
+		return
+	} else if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	u.Password, err = h.Passwords.Hash(r.FormValue("password"))
+	if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
 	}
 	err = h.UserService.CreateUser(&u)
-	util.HandleError(err)
-	err = h.Templates.ExecuteTemplate(w, "index.gohtml", "User was succesfully created. Login to continue.")
-	util.HandleError(err)
-	return
+	if errors.Is(err, finisafricae.ErrConflict) || errors.Is(err, finisafricae.ErrInvalid) {
+		//A user with the given email or username is already present in the db. User is sent back.
+		w.WriteHeader(ErrorStatus(err))
+		render(w, r, h.Templates, "index.gohtml", finisafricae.ErrorMessage(err))
+		return
+	} else if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	//The user can login once the email is confirmed
+	if err := sendVerification(h.Links, &u, u.Email, h.VerifyFor); err != nil {
+		log.Printf("mailing verification link: %v", err)
+	}
+	render(w, r, h.Templates, "index.gohtml", "User was succesfully created. Follow the link mailed to "+u.Email+" to confirm your email, then login.")
 }
 
 type UserHandler struct {
-	UserService    finisafricae.UserService
-	SessionService finisafricae.SessionService
-	Templates      *template.Template
+	Templates Templates
 }
 
 func (h *UserHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if !isLoggedIn(h.SessionService, h.UserService, r) {
+	render(w, r, h.Templates, "user.gohtml", nil)
+}
+
+type SessionsHandler struct {
+	Sessions  *SessionManager
+	Audit     *Auditor
+	Templates Templates
+}
+
+//sessionsPage is the data of sessions.gohtml
+type sessionsPage struct {
+	Current  string
+	Sessions []*finisafricae.Session
+}
+
+//ServeHTTP lists the sessions of the current user on GET. POST revokes the session with the id of the form, or all of them with "all".
+func (h *SessionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := finisafricae.UserFromContext(r.Context())
+	cur := finisafricae.SessionFromContext(r.Context())
+	ses, err := h.Sessions.SessionService.SessionsForUser(u.ID)
+	if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	if r.Method != "POST" {
+		render(w, r, h.Templates, "sessions.gohtml", sessionsPage{Current: cur.ID, Sessions: ses})
+		return
+	}
+	if r.FormValue("all") != "" {
+		//Sign out everywhere, including here, and forget remembered logins, which would log the user back in
+		if err := h.Sessions.EndUser(w, r, u.ID); err != nil {
+			renderError(w, r, h.Templates, err)
+			return
+		}
+		h.Audit.Record(r, &finisafricae.AuditEvent{Type: finisafricae.AuditSessionRevoke, UserID: u.ID, Detail: "All sessions"})
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
-	err := h.Templates.ExecuteTemplate(w, "user.gohtml", nil)
-	util.HandleError(err)
-	return
+	for _, s := range ses {
+		if s.ID != r.FormValue("id") {
+			continue
+		}
+		h.Audit.Record(r, &finisafricae.AuditEvent{Type: finisafricae.AuditSessionRevoke, UserID: u.ID, Detail: "Session of " + s.UserAgent + " from " + s.IP})
+		if s.ID != cur.ID {
+			if err := h.Sessions.SessionService.DeleteSession(s.ID); err != nil {
+				renderError(w, r, h.Templates, err)
+				return
+			}
+			http.Redirect(w, r, "/sessions", http.StatusSeeOther)
+			return
+		}
+		//The current session is signed out, the same as logging out
+		if err := h.Sessions.Logout(w, r, s); err != nil {
+			renderError(w, r, h.Templates, err)
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	//Only the sessions of the current user can be revoked
+	renderError(w, r, h.Templates, finisafricae.Errorf(finisafricae.ErrNotFound, "The session was not found."))
 }
 
 type UpdatePasswordHandler struct {
-	UserService    finisafricae.UserService
-	SessionService finisafricae.SessionService
-	Templates      *template.Template
+	UserService finisafricae.UserService
+	Sessions    *SessionManager
+	Policy      *finisafricae.PasswordPolicy
+	Passwords   finisafricae.PasswordHasher
+	Audit       *Auditor
+	Templates   Templates
 }
 
 func (h *UpdatePasswordHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if !isLoggedIn(h.SessionService, h.UserService, r) {
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
-	}
-	err := r.ParseForm()
-	util.HandleError(err)
-	if r.Form["npassword"][0] != "" || r.Form["npassword2"][0] != "" {
-		//Requried fields are filled out. Retrieve cookie, session and current user.
-		c, err := r.Cookie("session")
-		util.HandleError(err)
-		s, err := h.SessionService.Session(c.Value)
-		util.HandleError(err)
-		u, err := h.UserService.User(s.UserID)
-		util.HandleError(err)
-		if err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(r.Form["password"][0])); err == nil {
+	if r.FormValue("npassword") != "" || r.FormValue("npassword2") != "" {
+		//Requried fields are filled out. Retrieve current user.
+		u := finisafricae.UserFromContext(r.Context())
+		if err := h.Passwords.Compare(u.Password, r.FormValue("password")); err == nil {
 			//The given password matches the users password.
-			if r.Form["npassword"][0] == r.Form["npassword2"][0] {
-				//New password matches repeat password. Hash new password and update current user.
-				p, err := bcrypt.GenerateFromPassword([]byte(r.Form["npassword"][0]), bcrypt.MinCost)
-				util.HandleError(err)
-				u.Password = string(p)
-				err = h.UserService.UpdateUser(u)
-				util.HandleError(err)
-				err = h.Templates.ExecuteTemplate(w, "user.gohtml", "Your password was updated")
-				util.HandleError(err)
+			if r.FormValue("npassword") == r.FormValue("npassword2") {
+				//New password matches repeat password. Check it is strong enough.
+				if err := h.Policy.Check(r.FormValue("npassword"), u); errors.Is(err, finisafricae.ErrInvalid) {
+					w.WriteHeader(http.StatusBadRequest)
+					render(w, r, h.Templates, "user.gohtml", finisafricae.ErrorMessage(err))
+					return
+				} else if err != nil {
+					renderError(w, r, h.Templates, err)
+					return
+				}
+				//Hash new password and update current user.
+				p, err := h.Passwords.Hash(r.FormValue("npassword"))
+				if err != nil {
+					renderError(w, r, h.Templates, err)
+					return
+				}
+				u.Password = p
+				if err := h.UserService.UpdateUser(u); err != nil {
+					renderError(w, r, h.Templates, err)
+					return
+				}
+				//The credentials changed, so the session gets a new id and remembered logins are forgotten
+				if _, err := h.Sessions.Rotate(w, r, finisafricae.SessionFromContext(r.Context())); err != nil {
+					renderError(w, r, h.Templates, err)
+					return
+				}
+				if err := h.Sessions.ForgetUser(w, r, u.ID); err != nil {
+					renderError(w, r, h.Templates, err)
+					return
+				}
+				h.Audit.Record(r, &finisafricae.AuditEvent{Type: finisafricae.AuditPasswordChange, UserID: u.ID})
+				render(w, r, h.Templates, "user.gohtml", "Your password was updated")
 				return
 			}
 			//New password and repeat password doesn't match.
-			err := h.Templates.ExecuteTemplate(w, "user.gohtml", "The passwords doesn't match. Try again.")
-			util.HandleError(err)
+			w.WriteHeader(http.StatusBadRequest)
+			render(w, r, h.Templates, "user.gohtml", "The passwords doesn't match. Try again.")
 			return
 
 		}
 		//The give password doesn't match the user
-		err = h.Templates.ExecuteTemplate(w, "user.gohtml", "Wrong password.")
-		util.HandleError(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		render(w, r, h.Templates, "user.gohtml", "Wrong password.")
 		return
 	}
 	http.Redirect(w, r, "/user", http.StatusSeeOther)
-	return
 }
 
 type UpdateEmailHandler struct {
-	UserService    finisafricae.UserService
-	SessionService finisafricae.SessionService
-	Templates      *template.Template
+	UserService finisafricae.UserService
+	Links       *LinkMailer
+	Passwords   finisafricae.PasswordHasher
+	Templates   Templates
+	//VerifyFor is how long the link confirming the new email works
+	VerifyFor time.Duration
 }
 
+//ServeHTTP mails a link to the new email of the form. The email of the user changes when the link is followed.
 func (h *UpdateEmailHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if !isLoggedIn(h.SessionService, h.UserService, r) {
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
-	}
-	//Retrieve cookie, session and user.
-	err := r.ParseForm()
-	util.HandleError(err)
-	c, err := r.Cookie("session")
-	util.HandleError(err)
-	s, err := h.SessionService.Session(c.Value)
-	util.HandleError(err)
-	u, err := h.UserService.User(s.UserID)
-	util.HandleError(err)
-
-	if r.Form["email"][0] != "" {
+	u := finisafricae.UserFromContext(r.Context())
+	email := r.FormValue("email")
+
+	if email != "" {
 		//Email field is not empty
-		if err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(r.Form["password"][0])); err == nil {
+		if err := h.Passwords.Compare(u.Password, r.FormValue("password")); err == nil {
 			//Given password matches the users password
-			if u1, _ := h.UserService.UserFromEmail(r.Form["email"][0]); u1 == nil {
-				//New email is not taken
-				u.Email = r.Form["email"][0]
-				err := h.UserService.UpdateUser(u)
-				util.HandleError(err)
-				m := "Your email was updated to " + u.Email
-				err = h.Templates.ExecuteTemplate(w, "user.gohtml", m)
-				util.HandleError(err)
+			if err := finisafricae.ValidateEmail(email); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				render(w, r, h.Templates, "user.gohtml", finisafricae.ErrorMessage(err))
+				return
+			}
+			if _, err := h.UserService.UserFromEmail(email); err == nil {
+				//Email is already taken
+				w.WriteHeader(http.StatusConflict)
+				render(w, r, h.Templates, "user.gohtml", "A user with this email already exist. Try again.")
+				return
+			} else if !errors.Is(err, finisafricae.ErrNotFound) {
+				renderError(w, r, h.Templates, err)
 				return
 			}
-			//Email is already taken
-			err = h.Templates.ExecuteTemplate(w, "user.gohtml", "A user with this email already exist. Try again.")
-			util.HandleError(err)
+			if err := sendVerification(h.Links, u, email, h.VerifyFor); err != nil {
+				renderError(w, r, h.Templates, err)
+				return
+			}
+			render(w, r, h.Templates, "user.gohtml", "A link has been mailed to "+email+". Your email changes when you follow it.")
 			return
 		}
 		//Given password doesn't match the user password
-		err = h.Templates.ExecuteTemplate(w, "user.gohtml", "Wrong password.")
-		util.HandleError(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		render(w, r, h.Templates, "user.gohtml", "Wrong password.")
 		return
 	}
 	http.Redirect(w, r, "/user", http.StatusSeeOther)
@@ -254,162 +411,166 @@ func (h *UpdateEmailHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 type HomeHandler struct {
-	UserService    finisafricae.UserService
-	BookService    finisafricae.BookService
-	SessionService finisafricae.SessionService
-
-	Templates *template.Template
+	BookService finisafricae.BookService
+	Templates   Templates
 }
 
 func (h *HomeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if !isLoggedIn(h.SessionService, h.UserService, r) {
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+	u := finisafricae.UserFromContext(r.Context())
+	books, err := h.BookService.Books(u.ID)
+	if err != nil {
+		renderError(w, r, h.Templates, err)
 		return
 	}
-	err := r.ParseForm()
-	util.HandleError(err)
-	c, err := r.Cookie("session")
-	util.HandleError(err)
-	s, err := h.SessionService.Session(c.Value)
-	util.HandleError(err)
-	books, err := h.BookService.Books(s.UserID)
-	util.HandleError(err)
-	err = h.Templates.ExecuteTemplate(w, "home.gohtml", books)
-	util.HandleError(err)
+	render(w, r, h.Templates, "home.gohtml", books)
 }
 
 type BookHandler struct {
-	UserService    finisafricae.UserService
-	BookService    finisafricae.BookService
-	SessionService finisafricae.SessionService
-	Templates      *template.Template
+	BookService finisafricae.BookService
+	Templates   Templates
 }
 
 func (h *BookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if !isLoggedIn(h.SessionService, h.UserService, r) {
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
-	}
-	err := h.Templates.ExecuteTemplate(w, "book.gohtml", nil)
-	util.HandleError(err)
+	render(w, r, h.Templates, "book.gohtml", nil)
 }
 
 type NewBookHandler struct {
-	UserService    finisafricae.UserService
-	BookService    finisafricae.BookService
-	SessionService finisafricae.SessionService
-	Templates      *template.Template
+	Templates Templates
 }
 
 func (h *NewBookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if !isLoggedIn(h.SessionService, h.UserService, r) {
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
-	}
-	err := h.Templates.ExecuteTemplate(w, "newbook.gohtml", nil)
-	util.HandleError(err)
+	render(w, r, h.Templates, "newbook.gohtml", nil)
 }
 
 type SaveBookHandler struct {
-	UserService    finisafricae.UserService
-	BookService    finisafricae.BookService
-	SessionService finisafricae.SessionService
-	Templates      *template.Template
+	BookService finisafricae.BookService
+	Audit       *Auditor
+	Templates   Templates
 }
 
 func (h *SaveBookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if !isLoggedIn(h.SessionService, h.UserService, r) {
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
-	}
-	//Retrieve cookie, session and books of current user.
-	err := r.ParseForm()
-	util.HandleError(err)
-	c, err := r.Cookie("session")
-	util.HandleError(err)
-	s, err := h.SessionService.Session(c.Value)
-	util.HandleError(err)
-	books, err := h.BookService.Books(s.UserID)
-	util.HandleError(err)
-
-	if r.Form["title"][0] == "" {
-		//The title field of the form is not filled
-		err = h.Templates.ExecuteTemplate(w, "newbook.gohtml", "The book must have a title.")
-		util.HandleError(err)
+	u := finisafricae.UserFromContext(r.Context())
+	bID, err := uuid.NewV4()
+	if err != nil {
+		renderError(w, r, h.Templates, err)
 		return
 	}
-	for i := range books {
-		//Ranges through books to see if title already exists (distinct titles are allowed)
-		if books[i].Title == r.Form["title"][0] {
-			//Title match found. User sendt back to form page.
-			err = h.Templates.ExecuteTemplate(w, "newbook.gohtml", "A book with this title already exists.")
-			util.HandleError(err)
-			return
-		}
-	}
-	//No title found that matches new title. New book created and stored. User sent back to home.
-	bID, _ := uuid.NewV4()
 	b := finisafricae.Book{
 		ID:     bID.String(),
-		Title:  r.Form["title"][0],
-		UserID: s.UserID,
-		Author: r.Form["author"][0],
-		Year:   r.Form["year"][0],
-		Genre:  r.Form["genre"][0],
-		Notes:  r.Form["notes"][0],
+		Title:  r.FormValue("title"),
+		UserID: u.ID,
+		Author: r.FormValue("author"),
+		Year:   r.FormValue("year"),
+		Genre:  r.FormValue("genre"),
+		Notes:  r.FormValue("notes"),
+		ISBN:   r.FormValue("isbn"),
 	}
 	err = h.BookService.CreateBook(&b)
-	util.HandleError(err)
+	if errors.Is(err, finisafricae.ErrInvalid) || errors.Is(err, finisafricae.ErrConflict) {
+		//The title is missing or already exists (distinct titles are allowed). User sent back to form page.
+		w.WriteHeader(ErrorStatus(err))
+		render(w, r, h.Templates, "newbook.gohtml", finisafricae.ErrorMessage(err))
+		return
+	} else if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	h.Audit.Record(r, &finisafricae.AuditEvent{Type: finisafricae.AuditBookCreate, UserID: u.ID, Detail: b.Title})
+	//New book created and stored. User sent back to home.
 	http.Redirect(w, r, "/home", http.StatusSeeOther)
-	return
 }
 
-type ShareHandler struct {
-	UserService    finisafricae.UserService
-	BookService    finisafricae.BookService
-	SessionService finisafricae.SessionService
-	Templates      *template.Template
+//importPage is the data of import.gohtml
+type importPage struct {
+	Message string
+	Skipped []importer.Skipped
 }
 
-func (h *ShareHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if !isLoggedIn(h.SessionService, h.UserService, r) {
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+type ImportHandler struct {
+	BookService finisafricae.BookService
+	Audit       *Auditor
+	Templates   Templates
+}
+
+func (h *ImportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		render(w, r, h.Templates, "import.gohtml", importPage{})
+		return
+	}
+	u := finisafricae.UserFromContext(r.Context())
+
+	err := r.ParseMultipartForm(32 << 20)
+	if err != nil || len(r.MultipartForm.File["file"]) == 0 {
+		//No file was uploaded
+		w.WriteHeader(http.StatusBadRequest)
+		render(w, r, h.Templates, "import.gohtml", importPage{Message: "Choose a file to import."})
+		return
+	}
+	var bs []*finisafricae.Book
+	for _, fh := range r.MultipartForm.File["file"] {
+		fbs, err := readUpload(fh, r.FormValue("format"))
+		if err != nil {
+			//The file could not be parsed in the chosen format
+			w.WriteHeader(http.StatusBadRequest)
+			render(w, r, h.Templates, "import.gohtml", importPage{Message: fh.Filename + " could not be read: " + err.Error()})
+			return
+		}
+		bs = append(bs, fbs...)
+	}
+	skipped, err := importer.Import(h.BookService, u.ID, bs)
+	if err != nil {
+		renderError(w, r, h.Templates, err)
 		return
 	}
-	err := h.Templates.ExecuteTemplate(w, "share.gohtml", nil)
-	util.HandleError(err)
+	n := len(bs) - len(skipped)
+	h.Audit.Record(r, &finisafricae.AuditEvent{Type: finisafricae.AuditBookCreate, UserID: u.ID, Detail: fmt.Sprintf("Imported %d books", n)})
+	render(w, r, h.Templates, "import.gohtml", importPage{Message: fmt.Sprintf("Imported %d books, skipped %d.", n, len(skipped)), Skipped: skipped})
+}
+
+type ExportHandler struct {
+	BookService finisafricae.BookService
+	Templates   Templates
 }
 
-//Returns true if user is logged in
-func isLoggedIn(SessionService finisafricae.SessionService, UserService finisafricae.UserService, r *http.Request) bool {
-	//Parse form and get cookie
-	err := r.ParseForm()
-	util.HandleError(err)
-	c, err := r.Cookie("session")
+func (h *ExportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := finisafricae.UserFromContext(r.Context())
+	books, err := h.BookService.Books(u.ID)
+	if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	switch r.FormValue("format") {
+	case "marc":
+		w.Header().Set("Content-Type", "application/marc")
+		w.Header().Set("Content-Disposition", `attachment; filename="library.mrc"`)
+		err = marc.Write(w, books)
+	default:
+		w.Header().Set("Content-Type", "application/marcxml+xml")
+		w.Header().Set("Content-Disposition", `attachment; filename="library.xml"`)
+		err = marc.WriteXML(w, books)
+	}
 	if err != nil {
-		return false
+		//Headers are already sent, so the error can only be logged
+		log.Printf("exporting library: %v", err)
 	}
-	//Cookie exists. Get session.
-	s, err := SessionService.Session(c.Value)
+}
+
+//readUpload reads the books of an uploaded file in the given format, or the format of its name if none is given
+func readUpload(fh *multipart.FileHeader, format string) ([]*finisafricae.Book, error) {
+	f, err := fh.Open()
 	if err != nil {
-		return false
+		return nil, err
 	}
-	//Session exists. Tjek if it is expired.
-	t, err := time.Parse(time.RFC1123, s.Time)
+	defer f.Close()
+	return importer.Read(f, fh.Filename, fh.Size, format)
+}
+
+//rehash replaces the stored hash of the password of u with a new one from ph
+func rehash(s finisafricae.UserService, ph finisafricae.PasswordHasher, u *finisafricae.User, password string) error {
+	p, err := ph.Hash(password)
 	if err != nil {
-		return false
-	}
-	if time.Now().Sub(t) > (time.Second * 300) {
-		//Session expired. Delete session and cookie.
-		err := SessionService.DeleteSession(s.ID)
-		c.MaxAge = -1
-		util.HandleError(err)
-		return false
-	}
-	//Session is valid. Update time and session.
-	t = time.Now()
-	s.Time = t.Format(time.RFC1123)
-	err = SessionService.UpdateSession(s)
-	util.HandleError(err)
-	return true
+		return err
+	}
+	u.Password = p
+	return s.UpdateUser(u)
 }
@@ -0,0 +1,36 @@
+package http
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//LinkMailer mails users links holding one time tokens, such as for password resets and email verification
+type LinkMailer struct {
+	OneTimeTokenService finisafricae.OneTimeTokenService
+	Mailer              finisafricae.Mailer
+	//BaseURL is the URL users reach the server at, without a trailing slash
+	BaseURL string
+}
+
+//Send mails to a link to path holding a new token for the purpose of t, valid for d. Earlier tokens of the user for
+//the same purpose stop working, so only the newest link does. body is a format string with a %s for the link.
+func (l *LinkMailer) Send(to string, t *finisafricae.OneTimeToken, d time.Duration, path, subject, body string) error {
+	if err := l.OneTimeTokenService.DeleteOneTimeTokensForUser(t.UserID, t.Purpose); err != nil {
+		return err
+	}
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	t.Hash = hashToken(token)
+	t.Expires = time.Now().Add(d).UTC()
+	if err := l.OneTimeTokenService.CreateOneTimeToken(t); err != nil {
+		return err
+	}
+	link := l.BaseURL + path + "?" + url.Values{"token": {token}}.Encode()
+	return l.Mailer.Send(&finisafricae.Mail{To: to, Subject: subject, Body: fmt.Sprintf(body, link)})
+}
@@ -0,0 +1,314 @@
+package http
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+	"github.com/madskrogh/finisafricae/oidc"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	//oidcCookie holds the state of a sign in with the OpenID Connect provider while the user is away at the provider
+	oidcCookie = "oidc"
+	//oidcSignInFor is how long users have to sign in at the provider
+	oidcSignInFor = 10 * time.Minute
+)
+
+type signInContextKey struct{}
+
+//SignIn wraps next so pages offer signing in with the provider of the given name
+func SignIn(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), signInContextKey{}, name)))
+	})
+}
+
+//signInName returns the name of the provider users can sign in with, or "" if there is none
+func signInName(r *http.Request) string {
+	n, _ := r.Context().Value(signInContextKey{}).(string)
+	return n
+}
+
+//OIDCHandler signs users in with an OpenID Connect provider. /login/oidc sends the user to the provider, which sends them
+//back to /login/oidc/callback. A logged out user is logged in as the user their account at the provider is linked to. If no
+//user is, the account is linked to the user with the same email, or a new user is created. A logged in user gets the account
+//linked to them instead.
+type OIDCHandler struct {
+	UserService         finisafricae.UserService
+	IdentityService     finisafricae.IdentityService
+	OneTimeTokenService finisafricae.OneTimeTokenService
+	Sessions            *SessionManager
+	Provider            *oidc.Provider
+	Passwords           finisafricae.PasswordHasher
+	Audit               *Auditor
+	Templates           Templates
+}
+
+func (h *OIDCHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/callback") {
+		h.callback(w, r)
+		return
+	}
+	state, err := newToken()
+	if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	nonce, err := newToken()
+	if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	v := url.Values{"state": {state}, "nonce": {nonce}, "verifier": {oidc.NewVerifier()}}
+	if u := finisafricae.UserFromContext(r.Context()); u != nil {
+		//An admin acting as the user would link their own account at the provider, and could then sign in as the user at will
+		if finisafricae.ImpersonatorFromContext(r.Context()) != nil {
+			renderError(w, r, h.Templates, errImpersonating)
+			return
+		}
+		v.Set("link", u.ID)
+	}
+	//The cookie binds the callback to this browser, so nobody can make a user sign in as someone else
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    v.Encode(),
+		Path:     "/login/oidc",
+		MaxAge:   int(oidcSignInFor.Seconds()),
+		HttpOnly: true,
+		Secure:   h.Sessions.SecureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, h.Provider.AuthCodeURL(state, nonce, v.Get("verifier")), http.StatusFound)
+}
+
+//callback completes the sign in when the provider sends the user back
+func (h *OIDCHandler) callback(w http.ResponseWriter, r *http.Request) {
+	var v url.Values
+	if c, err := r.Cookie(oidcCookie); err == nil {
+		v, _ = url.ParseQuery(c.Value)
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Path: "/login/oidc", MaxAge: -1, HttpOnly: true, Secure: h.Sessions.SecureCookie, SameSite: http.SameSiteLaxMode})
+	state := r.FormValue("state")
+	if v.Get("state") == "" || subtle.ConstantTimeCompare([]byte(state), []byte(v.Get("state"))) != 1 {
+		h.fail(w, r, finisafricae.Errorf(finisafricae.ErrInvalid, "The sign in has expired. Try again."))
+		return
+	}
+	if r.FormValue("error") != "" {
+		h.fail(w, r, finisafricae.Errorf(finisafricae.ErrUnauthorized, "Sign in with %s was cancelled.", h.Provider.Name))
+		return
+	}
+	c, err := h.Provider.Exchange(r.Context(), r.FormValue("code"), v.Get("nonce"), v.Get("verifier"))
+	if err != nil {
+		log.Printf("sign in with %s: %v", h.Provider.Name, err)
+		h.fail(w, r, finisafricae.Errorf(finisafricae.ErrUnauthorized, "Sign in with %s failed. Try again.", h.Provider.Name))
+		return
+	}
+
+	if link := v.Get("link"); link != "" {
+		u := finisafricae.UserFromContext(r.Context())
+		if u == nil || u.ID != link {
+			h.fail(w, r, finisafricae.Errorf(finisafricae.ErrUnauthorized, "You were logged out before the account was linked. Login and try again."))
+			return
+		}
+		if finisafricae.ImpersonatorFromContext(r.Context()) != nil {
+			h.fail(w, r, errImpersonating)
+			return
+		}
+		if err := h.link(u, c); err != nil {
+			h.fail(w, r, err)
+			return
+		}
+		http.Redirect(w, r, "/identities", http.StatusSeeOther)
+		return
+	}
+
+	u, err := h.user(c)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	if u.Disabled {
+		h.fail(w, r, errDisabled)
+		return
+	}
+	if u.TOTPSecret != "" {
+		//The provider vouches for the account, but users who turned on two-factor authentication here still enter a code
+		startTOTPLogin(w, r, h.Templates, h.OneTimeTokenService, u, false)
+		return
+	}
+	if err := keepAccount(h.UserService, u); err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	if err := h.Sessions.Login(w, r, u.ID, false); err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	h.Audit.Record(r, &finisafricae.AuditEvent{Type: finisafricae.AuditLogin, UserID: u.ID, Detail: "Sign in with " + h.Provider.Name})
+	http.Redirect(w, r, "/home", http.StatusSeeOther)
+}
+
+//fail renders the page the user started signing in from with the message of err
+func (h *OIDCHandler) fail(w http.ResponseWriter, r *http.Request, err error) {
+	if ErrorStatus(err) == http.StatusInternalServerError {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	w.WriteHeader(ErrorStatus(err))
+	if finisafricae.UserFromContext(r.Context()) != nil {
+		render(w, r, h.Templates, "user.gohtml", finisafricae.ErrorMessage(err))
+		return
+	}
+	render(w, r, h.Templates, "index.gohtml", finisafricae.ErrorMessage(err))
+}
+
+//link links the account of the claims c to the user u
+func (h *OIDCHandler) link(u *finisafricae.User, c *oidc.Claims) error {
+	i, err := h.IdentityService.Identity(c.Issuer, c.Subject)
+	if err == nil && i.UserID == u.ID {
+		return nil
+	} else if err == nil {
+		return finisafricae.Errorf(finisafricae.ErrConflict, "The %s account is linked to another user.", h.Provider.Name)
+	} else if !errors.Is(err, finisafricae.ErrNotFound) {
+		return err
+	}
+	return h.IdentityService.CreateIdentity(&finisafricae.Identity{Issuer: c.Issuer, Subject: c.Subject, UserID: u.ID, Email: c.Email, Created: time.Now().UTC()})
+}
+
+//user returns the user the account of the claims c is linked to. An account signing in for the first time is linked to the user
+//with its email, or to a new user if there is none.
+func (h *OIDCHandler) user(c *oidc.Claims) (*finisafricae.User, error) {
+	i, err := h.IdentityService.Identity(c.Issuer, c.Subject)
+	if err == nil {
+		return h.UserService.User(i.UserID)
+	} else if !errors.Is(err, finisafricae.ErrNotFound) {
+		return nil, err
+	}
+	if c.Email == "" || !c.EmailVerified {
+		return nil, finisafricae.Errorf(finisafricae.ErrForbidden, "%s didn't confirm your email. Confirm it there and try again.", h.Provider.Name)
+	}
+	u, err := h.UserService.UserFromEmail(c.Email)
+	if err == nil && !u.Verified {
+		//Whoever signed up with the email never confirmed it, and may know the password. Resetting it proves the email is theirs.
+		return nil, finisafricae.Errorf(finisafricae.ErrConflict, "A user with your email exists but the email isn't confirmed. Reset the password of the user, then sign in with %s again.", h.Provider.Name)
+	} else if errors.Is(err, finisafricae.ErrNotFound) {
+		u, err = h.createUser(c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u, h.link(u, c)
+}
+
+//createUser creates a user for the account of the claims c. The user has a random password, which they can reset to login
+//without the provider.
+func (h *OIDCHandler) createUser(c *oidc.Claims) (*finisafricae.User, error) {
+	uID, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+	p, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := h.Passwords.Hash(p)
+	if err != nil {
+		return nil, err
+	}
+	uname := c.Username
+	if uname == "" {
+		//Providers may send emails without an @, whose whole email then makes the username
+		uname = c.Email
+		if at := strings.Index(c.Email, "@"); at >= 0 {
+			uname = c.Email[:at]
+		}
+	}
+	uname = usernameFrom(uname)
+	u := &finisafricae.User{ID: uID.String(), Uname: uname, Email: c.Email, Password: hash, Verified: true, Role: finisafricae.RoleMember}
+	//The username may be taken, in which case a number is added. No user has the email, so conflicts are about the username.
+	for i := 2; ; i++ {
+		err = h.UserService.CreateUser(u)
+		if !errors.Is(err, finisafricae.ErrConflict) || i > oidcUsernameTries {
+			return u, err
+		}
+		suffix, base := strconv.Itoa(i), uname
+		if len(base) > finisafricae.UsernameMaxLength-len(suffix) {
+			base = base[:finisafricae.UsernameMaxLength-len(suffix)]
+		}
+		u.Uname = base + suffix
+	}
+}
+
+//oidcUsernameTries is how many numbered usernames are tried for a new user whose username is taken
+const oidcUsernameTries = 20
+
+//usernameFrom returns a valid username made from s, such as the username of an account at the provider, by leaving out
+//the characters usernames can't have
+func usernameFrom(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if b.Len() == finisafricae.UsernameMaxLength {
+			break
+		}
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || b.Len() > 0 && (c == '.' || c == '-' || c == '_') {
+			b.WriteRune(c)
+		}
+	}
+	uname := b.String()
+	if uname == "" {
+		uname = "user"
+	}
+	for len(uname) < finisafricae.UsernameMinLength {
+		uname += "0"
+	}
+	return uname
+}
+
+//IdentitiesHandler lists the accounts at the OpenID Connect provider linked to the user on GET. POST unlinks the account with
+//the subject of the form.
+type IdentitiesHandler struct {
+	IdentityService finisafricae.IdentityService
+	Provider        *oidc.Provider
+	Templates       Templates
+}
+
+//identitiesPage is the data of identities.gohtml
+type identitiesPage struct {
+	Provider   string
+	Identities []*finisafricae.Identity
+}
+
+func (h *IdentitiesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := finisafricae.UserFromContext(r.Context())
+	is, err := h.IdentityService.IdentitiesForUser(u.ID)
+	if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	if r.Method != "POST" {
+		render(w, r, h.Templates, "identities.gohtml", identitiesPage{Provider: h.Provider.Name, Identities: is})
+		return
+	}
+	for _, i := range is {
+		if i.Issuer == r.FormValue("issuer") && i.Subject == r.FormValue("subject") {
+			if err := h.IdentityService.DeleteIdentity(i.Issuer, i.Subject); err != nil {
+				renderError(w, r, h.Templates, err)
+				return
+			}
+			http.Redirect(w, r, "/identities", http.StatusSeeOther)
+			return
+		}
+	}
+	//Only the accounts of the current user can be unlinked
+	renderError(w, r, h.Templates, finisafricae.Errorf(finisafricae.ErrNotFound, "The account was not found."))
+}
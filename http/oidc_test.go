@@ -0,0 +1,246 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+	"github.com/madskrogh/finisafricae/oidc"
+	"github.com/madskrogh/finisafricae/oidc/oidctest"
+)
+
+//oidcTest is an OIDCHandler signing in with a fake issuer
+type oidcTest struct {
+	t          *testing.T
+	iss        *oidctest.Issuer
+	h          *OIDCHandler
+	users      *memUsers
+	identities *memIdentities
+	sessions   *memSessions
+}
+
+func newOIDCTest(t *testing.T, users ...*finisafricae.User) *oidcTest {
+	iss, err := oidctest.NewIssuer("client", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(iss.Close)
+	p, err := oidc.New(context.Background(), "Test", iss.URL, "client", "secret", "http://finisafricae.test/login/oidc/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ot := &oidcTest{t: t, iss: iss, users: &memUsers{users: users}, identities: &memIdentities{}, sessions: &memSessions{}}
+	ot.h = &OIDCHandler{
+		UserService:     ot.users,
+		IdentityService: ot.identities,
+		Sessions:        &SessionManager{SessionService: ot.sessions, IdleTimeout: time.Hour, AbsoluteTimeout: time.Hour},
+		Provider:        p,
+		Passwords:       plainHasher{},
+		Templates:       testTemplates(t),
+	}
+	return ot
+}
+
+//signIn signs in at the issuer as pu, with u logged in unless it is nil. tamper may change the callback request before
+//it is served.
+func (ot *oidcTest) signIn(pu oidctest.User, u *finisafricae.User, tamper func(*http.Request)) *httptest.ResponseRecorder {
+	ot.t.Helper()
+	ot.iss.SignIn(pu)
+	withUser := func(r *http.Request) *http.Request {
+		if u == nil {
+			return r
+		}
+		return r.WithContext(finisafricae.NewContextWithUser(r.Context(), u))
+	}
+	w := httptest.NewRecorder()
+	ot.h.ServeHTTP(w, withUser(httptest.NewRequest("GET", "/login/oidc", nil)))
+	if w.Code != http.StatusFound {
+		ot.t.Fatalf("starting sign in: status %d", w.Code)
+	}
+	back, err := ot.iss.Authorize(w.Header().Get("Location"))
+	if err != nil {
+		ot.t.Fatal(err)
+	}
+	r := withUser(httptest.NewRequest("GET", "/login/oidc/callback?"+back.RawQuery, nil))
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	if tamper != nil {
+		tamper(r)
+	}
+	w = httptest.NewRecorder()
+	ot.h.ServeHTTP(w, r)
+	return w
+}
+
+//linked returns the id of the user the account of the issuer with the subject is linked to, or ""
+func (ot *oidcTest) linked(subject string) string {
+	i, err := ot.identities.Identity(ot.iss.URL, subject)
+	if err != nil {
+		return ""
+	}
+	return i.UserID
+}
+
+var reader = &finisafricae.User{ID: "r", Uname: "reader", Email: "reader@example.com", Password: "plain:password", Verified: true, Role: finisafricae.RoleMember}
+
+func TestOIDCNewUser(t *testing.T) {
+	ot := newOIDCTest(t)
+	w := ot.signIn(oidctest.User{Subject: "1", Email: "new@example.com", EmailVerified: true, PreferredUsername: "new.reader"}, nil, nil)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/home" {
+		t.Fatalf("got status %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	u, err := ot.users.UserFromEmail("new@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Uname != "new.reader" || !u.Verified || ot.linked("1") != u.ID || len(ot.sessions.sessions) != 1 {
+		t.Errorf("got user %+v linked to %q with %d sessions", u, ot.linked("1"), len(ot.sessions.sessions))
+	}
+}
+
+func TestOIDCStateMismatch(t *testing.T) {
+	for name, tamper := range map[string]func(*http.Request){
+		"wrong state": func(r *http.Request) {
+			q := r.URL.Query()
+			q.Set("state", "forged")
+			r.URL.RawQuery = q.Encode()
+		},
+		"no cookie": func(r *http.Request) { r.Header.Del("Cookie") },
+	} {
+		ot := newOIDCTest(t)
+		w := ot.signIn(oidctest.User{Subject: "1", Email: "new@example.com", EmailVerified: true}, nil, tamper)
+		if w.Code != http.StatusBadRequest || len(ot.users.users) != 0 || len(ot.sessions.sessions) != 0 {
+			t.Errorf("%s: got status %d, %d users and %d sessions", name, w.Code, len(ot.users.users), len(ot.sessions.sessions))
+		}
+	}
+}
+
+func TestOIDCLinkLoggedIn(t *testing.T) {
+	ot := newOIDCTest(t, reader)
+	//The email at the provider needn't be the email of the user
+	w := ot.signIn(oidctest.User{Subject: "1", Email: "work@example.com", EmailVerified: true}, reader, nil)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/identities" || ot.linked("1") != reader.ID {
+		t.Fatalf("got status %d to %q, linked to %q", w.Code, w.Header().Get("Location"), ot.linked("1"))
+	}
+	if len(ot.users.users) != 1 || len(ot.sessions.sessions) != 0 {
+		t.Errorf("linking created %d users and %d sessions", len(ot.users.users)-1, len(ot.sessions.sessions))
+	}
+	other := &finisafricae.User{ID: "o", Uname: "other", Email: "other@example.com", Password: "plain:password", Verified: true, Role: finisafricae.RoleMember}
+	ot.users.users = append(ot.users.users, other)
+	if w := ot.signIn(oidctest.User{Subject: "1"}, other, nil); w.Code != http.StatusConflict || ot.linked("1") != reader.ID {
+		t.Errorf("linking an account linked to another user: got status %d, linked to %q", w.Code, ot.linked("1"))
+	}
+}
+
+func TestOIDCLinkLoggedOutMeanwhile(t *testing.T) {
+	ot := newOIDCTest(t, reader)
+	ot.iss.SignIn(oidctest.User{Subject: "1"})
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/login/oidc", nil)
+	ot.h.ServeHTTP(w, r.WithContext(finisafricae.NewContextWithUser(r.Context(), reader)))
+	back, err := ot.iss.Authorize(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = httptest.NewRequest("GET", "/login/oidc/callback?"+back.RawQuery, nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	ot.h.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized || ot.linked("1") != "" {
+		t.Errorf("got status %d, linked to %q", w.Code, ot.linked("1"))
+	}
+}
+
+func TestOIDCLinkImpersonating(t *testing.T) {
+	admin := &finisafricae.User{ID: "a", Uname: "admin", Email: "admin@example.com", Password: "plain:password", Verified: true, Role: finisafricae.RoleAdmin}
+	ot := newOIDCTest(t, reader, admin)
+	impersonating := func(r *http.Request) *http.Request {
+		ctx := finisafricae.NewContextWithUser(r.Context(), reader)
+		return r.WithContext(finisafricae.NewContextWithImpersonator(ctx, admin))
+	}
+	ot.iss.SignIn(oidctest.User{Subject: "1", Email: "admin@example.com", EmailVerified: true})
+	w := httptest.NewRecorder()
+	ot.h.ServeHTTP(w, impersonating(httptest.NewRequest("GET", "/login/oidc", nil)))
+	if w.Code != http.StatusForbidden || w.Header().Get("Location") != "" {
+		t.Errorf("starting to link: got status %d to %q", w.Code, w.Header().Get("Location"))
+	}
+
+	//A link started by the user can't be completed by an admin who starts acting as them meanwhile
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/login/oidc", nil)
+	ot.h.ServeHTTP(w, r.WithContext(finisafricae.NewContextWithUser(r.Context(), reader)))
+	back, err := ot.iss.Authorize(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = impersonating(httptest.NewRequest("GET", "/login/oidc/callback?"+back.RawQuery, nil))
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	ot.h.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden || ot.linked("1") != "" {
+		t.Errorf("completing the link: got status %d, linked to %q", w.Code, ot.linked("1"))
+	}
+}
+
+func TestOIDCVerifiedEmail(t *testing.T) {
+	ot := newOIDCTest(t, reader)
+	w := ot.signIn(oidctest.User{Subject: "1", Email: reader.Email, EmailVerified: true, PreferredUsername: "someone"}, nil, nil)
+	if w.Code != http.StatusSeeOther || ot.linked("1") != reader.ID || len(ot.users.users) != 1 {
+		t.Fatalf("got status %d, linked to %q, %d users", w.Code, ot.linked("1"), len(ot.users.users))
+	}
+	//Once linked, the account signs in as the user whatever its email
+	w = ot.signIn(oidctest.User{Subject: "1", Email: "changed@example.com"}, nil, nil)
+	if w.Code != http.StatusSeeOther || len(ot.sessions.sessions) != 2 {
+		t.Errorf("signing in again: got status %d, %d sessions", w.Code, len(ot.sessions.sessions))
+	}
+}
+
+func TestOIDCUnverifiedEmail(t *testing.T) {
+	ot := newOIDCTest(t, reader)
+	//The provider hasn't confirmed the email, so it could be anyone's
+	w := ot.signIn(oidctest.User{Subject: "1", Email: reader.Email}, nil, nil)
+	if w.Code != http.StatusForbidden || ot.linked("1") != "" || len(ot.sessions.sessions) != 0 {
+		t.Errorf("unconfirmed at the provider: got status %d, linked to %q", w.Code, ot.linked("1"))
+	}
+	//The user hasn't confirmed the email, so whoever signed up may not own it
+	unverified := &finisafricae.User{ID: "u", Uname: "unverified", Email: "unverified@example.com", Password: "plain:password", Role: finisafricae.RoleMember}
+	ot.users.users = append(ot.users.users, unverified)
+	w = ot.signIn(oidctest.User{Subject: "2", Email: unverified.Email, EmailVerified: true}, nil, nil)
+	if w.Code != http.StatusConflict || ot.linked("2") != "" || len(ot.sessions.sessions) != 0 {
+		t.Errorf("unconfirmed here: got status %d, linked to %q", w.Code, ot.linked("2"))
+	}
+}
+
+func TestOIDCUsernameTaken(t *testing.T) {
+	reader2 := &finisafricae.User{ID: "r2", Uname: "Reader2", Email: "reader2@example.com", Password: "plain:password", Verified: true, Role: finisafricae.RoleMember}
+	ot := newOIDCTest(t, reader, reader2)
+	w := ot.signIn(oidctest.User{Subject: "1", Email: "third@example.com", EmailVerified: true, PreferredUsername: "reader"}, nil, nil)
+	u, err := ot.users.UserFromEmail("third@example.com")
+	if w.Code != http.StatusSeeOther || err != nil || u.Uname != "reader3" {
+		t.Errorf("got status %d, user %+v, %v", w.Code, u, err)
+	}
+}
+
+func TestOIDCUsernameFromEmail(t *testing.T) {
+	for email, want := range map[string]string{"ab+books@example.com": "abbooks", "x@example.com": "x00"} {
+		ot := newOIDCTest(t)
+		ot.signIn(oidctest.User{Subject: "1", Email: email, EmailVerified: true}, nil, nil)
+		u, err := ot.users.UserFromEmail(email)
+		if err != nil || u.Uname != want {
+			t.Errorf("%s: got user %+v, %v, want username %s", email, u, err, want)
+		}
+	}
+	//Providers may send anything as the email. It can't be used, but mustn't crash the server.
+	ot := newOIDCTest(t)
+	if w := ot.signIn(oidctest.User{Subject: "1", Email: "no-at", EmailVerified: true}, nil, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("email without @: got status %d", w.Code)
+	}
+}
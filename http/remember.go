@@ -0,0 +1,129 @@
+package http
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//rememberCookie is the name of the cookie holding the persistent token of a "remember me" login
+const rememberCookie = "remember"
+
+//Remember issues a persistent token for the user and sets the remember cookie, so a new session is started
+//when the user returns after the session has ended
+func (m *SessionManager) Remember(w http.ResponseWriter, userID string) error {
+	selector, err := newToken()
+	if err != nil {
+		return err
+	}
+	validator, err := newToken()
+	if err != nil {
+		return err
+	}
+	t := &finisafricae.PersistentToken{
+		Selector: selector,
+		UserID:   userID,
+		Hash:     hashToken(validator),
+		Expires:  time.Now().Add(m.RememberFor).UTC(),
+	}
+	if err := m.PersistentTokenService.CreatePersistentToken(t); err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     rememberCookie,
+		Value:    selector + ":" + validator,
+		Path:     "/",
+		Expires:  t.Expires,
+		HttpOnly: true,
+		Secure:   m.SecureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+//Forget deletes the persistent token of the remember cookie of r, if any, and clears the cookie
+func (m *SessionManager) Forget(w http.ResponseWriter, r *http.Request) error {
+	c, err := r.Cookie(rememberCookie)
+	if err != nil || m.PersistentTokenService == nil {
+		return nil
+	}
+	m.clearRememberCookie(w)
+	selector, _, _ := strings.Cut(c.Value, ":")
+	return m.PersistentTokenService.DeletePersistentToken(selector)
+}
+
+//ForgetUser deletes all persistent tokens of the user, such as when the password changes, and clears the remember cookie of r
+func (m *SessionManager) ForgetUser(w http.ResponseWriter, r *http.Request, userID string) error {
+	if _, err := r.Cookie(rememberCookie); err == nil {
+		m.clearRememberCookie(w)
+	}
+	if m.PersistentTokenService == nil {
+		return nil
+	}
+	return m.PersistentTokenService.DeletePersistentTokensForUser(userID)
+}
+
+//restore starts a new session from the remember cookie of r. The token is used once: it is replaced by a new one,
+//so a stolen cookie stops working when the real user returns. It returns nil if there is no valid token.
+func (m *SessionManager) restore(w http.ResponseWriter, r *http.Request) (*finisafricae.Session, error) {
+	if m.PersistentTokenService == nil {
+		return nil, nil
+	}
+	c, err := r.Cookie(rememberCookie)
+	if err != nil {
+		return nil, nil
+	}
+	selector, validator, ok := strings.Cut(c.Value, ":")
+	if !ok {
+		m.clearRememberCookie(w)
+		return nil, nil
+	}
+	t, err := m.PersistentTokenService.PersistentToken(selector)
+	if errors.Is(err, finisafricae.ErrNotFound) {
+		m.clearRememberCookie(w)
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(validator)), []byte(t.Hash)) != 1 {
+		//The selector is right but the validator is not. The token may have been stolen and used,
+		//so all remembered logins of the user are forgotten.
+		m.clearRememberCookie(w)
+		return nil, m.PersistentTokenService.DeletePersistentTokensForUser(t.UserID)
+	}
+	if err := m.PersistentTokenService.DeletePersistentToken(selector); err != nil {
+		return nil, err
+	}
+	if time.Now().After(t.Expires) {
+		m.clearRememberCookie(w)
+		return nil, nil
+	}
+	if err := m.Remember(w, t.UserID); err != nil {
+		return nil, err
+	}
+	return m.Start(w, r, t.UserID)
+}
+
+func (m *SessionManager) clearRememberCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     rememberCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   m.SecureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+//hashToken returns the hex encoded SHA-256 hash of a token. Tokens are random, so they need no salt or slow hash.
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,129 @@
+package http
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//ForgotPasswordHandler mails a password reset link to the user with the email of the form
+type ForgotPasswordHandler struct {
+	UserService finisafricae.UserService
+	Links       *LinkMailer
+	//ResetFor is how long the link works
+	ResetFor  time.Duration
+	Templates Templates
+}
+
+func (h *ForgotPasswordHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		render(w, r, h.Templates, "forgot.gohtml", nil)
+		return
+	}
+	//Whether the email belongs to a user or not, the response is the same, so the form can't be used to find users
+	const sent = "If a user with this email exists, a link to reset the password has been sent to it."
+	u, err := h.UserService.UserFromEmail(r.FormValue("email"))
+	if errors.Is(err, finisafricae.ErrNotFound) {
+		render(w, r, h.Templates, "forgot.gohtml", sent)
+		return
+	} else if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	reason := "Someone, hopefully you, asked to reset your finis Africae password."
+	if err := sendPasswordReset(h.Links, u, h.ResetFor, reason, "If you didn't ask for this, ignore this mail and your password stays the same."); err != nil {
+		//The user is not told, as that would show that the email belongs to a user
+		log.Printf("mailing password reset link: %v", err)
+	}
+	render(w, r, h.Templates, "forgot.gohtml", sent)
+}
+
+//sendPasswordReset mails u a link to choose a new password within d. reason and note are the sentences before and after the link.
+func sendPasswordReset(l *LinkMailer, u *finisafricae.User, d time.Duration, reason, note string) error {
+	t := &finisafricae.OneTimeToken{UserID: u.ID, Purpose: finisafricae.PurposePasswordReset}
+	body := reason + " Follow the link below within " + d.String() + " to choose a new one:\n\n%s\n\n" + note + "\n"
+	return l.Send(u.Email, t, d, "/reset", "Reset your finis Africae password", body)
+}
+
+//ResetPasswordHandler sets a new password for the user of a password reset link and logs them out everywhere
+type ResetPasswordHandler struct {
+	UserService         finisafricae.UserService
+	OneTimeTokenService finisafricae.OneTimeTokenService
+	Sessions            *SessionManager
+	Policy              *finisafricae.PasswordPolicy
+	Passwords           finisafricae.PasswordHasher
+	Audit               *Auditor
+	Templates           Templates
+}
+
+//resetPage is the data of reset.gohtml
+type resetPage struct {
+	Token   string
+	Message string
+}
+
+func (h *ResetPasswordHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	//The token is in the URL, which must not be sent to other sites in the Referer header
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	token := r.FormValue("token")
+	if r.Method != "POST" {
+		if _, err := h.OneTimeTokenService.OneTimeToken(hashToken(token), finisafricae.PurposePasswordReset); err != nil {
+			renderError(w, r, h.Templates, err)
+			return
+		}
+		render(w, r, h.Templates, "reset.gohtml", resetPage{Token: token})
+		return
+	}
+	if r.FormValue("npassword") == "" || r.FormValue("npassword") != r.FormValue("npassword2") {
+		w.WriteHeader(http.StatusBadRequest)
+		render(w, r, h.Templates, "reset.gohtml", resetPage{Token: token, Message: "The passwords are empty or don't match. Try again."})
+		return
+	}
+	t, err := h.OneTimeTokenService.OneTimeToken(hashToken(token), finisafricae.PurposePasswordReset)
+	if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	u, err := h.UserService.User(t.UserID)
+	if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	//The link keeps working until the new password is good enough
+	if err := h.Policy.Check(r.FormValue("npassword"), u); errors.Is(err, finisafricae.ErrInvalid) {
+		w.WriteHeader(http.StatusBadRequest)
+		render(w, r, h.Templates, "reset.gohtml", resetPage{Token: token, Message: finisafricae.ErrorMessage(err)})
+		return
+	} else if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	if _, err := h.OneTimeTokenService.UseOneTimeToken(hashToken(token), finisafricae.PurposePasswordReset); err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	if u.Password, err = h.Passwords.Hash(r.FormValue("npassword")); err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	//The link was mailed to the user, so the email is theirs
+	u.Verified = true
+	if err := h.UserService.UpdateUser(u); err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	h.Audit.Record(r, &finisafricae.AuditEvent{Type: finisafricae.AuditPasswordReset, UserID: u.ID, Detail: "Reset link"})
+	//Whoever knew the old password is logged out, and can't finish a login waiting for the second factor either
+	if err := h.Sessions.EndUser(w, r, u.ID); err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	if err := h.OneTimeTokenService.DeleteOneTimeTokensForUser(u.ID, finisafricae.PurposeLogin); err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	render(w, r, h.Templates, "index.gohtml", "Your password was reset. Login with your new password.")
+}
@@ -0,0 +1,56 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+func TestResetPasswordEndsLogins(t *testing.T) {
+	u := &finisafricae.User{ID: "r", Uname: "reader", Email: "reader@example.com", Password: "plain:old password", Verified: true, Role: finisafricae.RoleMember}
+	sessions := &memSessions{}
+	if err := sessions.CreateSession(&finisafricae.Session{ID: "s", UserID: u.ID, Created: time.Now(), LastSeen: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Now().Add(time.Hour)
+	tokens := &memTokens{tokens: []*finisafricae.OneTimeToken{
+		{Hash: hashToken("reset"), UserID: u.ID, Purpose: finisafricae.PurposePasswordReset, Expires: expires},
+		//Someone who knew the old password is at the second step of a login
+		{Hash: hashToken("login"), UserID: u.ID, Purpose: finisafricae.PurposeLogin, Expires: expires},
+		{Hash: hashToken("recovery"), UserID: u.ID, Purpose: finisafricae.PurposeRecoveryCode, Expires: expires},
+	}}
+	users := &memUsers{users: []*finisafricae.User{u}}
+	h := &ResetPasswordHandler{
+		UserService:         users,
+		OneTimeTokenService: tokens,
+		Sessions:            &SessionManager{SessionService: sessions},
+		Policy:              &finisafricae.PasswordPolicy{},
+		Passwords:           plainHasher{},
+		Templates:           testTemplates(t),
+	}
+	v := url.Values{"token": {"reset"}, "npassword": {"new password"}, "npassword2": {"new password"}}
+	r := httptest.NewRequest("POST", "/reset", strings.NewReader(v.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d", w.Code)
+	}
+	if got, _ := users.User(u.ID); got.Password != "plain:new password" {
+		t.Errorf("got password %q", got.Password)
+	}
+	if len(sessions.sessions) != 0 {
+		t.Errorf("%d sessions are left", len(sessions.sessions))
+	}
+	if _, err := tokens.OneTimeToken(hashToken("login"), finisafricae.PurposeLogin); err == nil {
+		t.Error("the login waiting for the second factor can still be finished")
+	}
+	if _, err := tokens.OneTimeToken(hashToken("recovery"), finisafricae.PurposeRecoveryCode); err != nil {
+		t.Error("the recovery codes were deleted")
+	}
+}
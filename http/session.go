@@ -0,0 +1,211 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//sessionCookie is the name of the cookie holding the session id
+const sessionCookie = "session"
+
+//SessionManager starts, rotates and ends sessions and owns the session cookie. A session ends when it has been idle
+//for longer than IdleTimeout, or at the latest AbsoluteTimeout after login.
+type SessionManager struct {
+	SessionService  finisafricae.SessionService
+	IdleTimeout     time.Duration
+	AbsoluteTimeout time.Duration
+	//SecureCookie restricts the cookies to https. It must only be disabled when serving plain http.
+	SecureCookie bool
+	//PersistentTokenService stores the tokens of "remember me" logins, which last RememberFor.
+	//Without it users are not remembered.
+	PersistentTokenService finisafricae.PersistentTokenService
+	RememberFor            time.Duration
+}
+
+//Start creates a new session for the user logging in with r and sets the session cookie
+func (m *SessionManager) Start(w http.ResponseWriter, r *http.Request, userID string) (*finisafricae.Session, error) {
+	id, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	s := &finisafricae.Session{ID: id, UserID: userID, Created: now, LastSeen: now, IP: clientIP(r), UserAgent: userAgent(r)}
+	if err := m.SessionService.CreateSession(s); err != nil {
+		return nil, err
+	}
+	m.setCookie(w, s)
+	return s, nil
+}
+
+//Login starts a session for the user, who has proven who they are, and remembers them if asked to.
+//A session id or CSRF token the client already had, possibly planted by someone else, is never reused.
+func (m *SessionManager) Login(w http.ResponseWriter, r *http.Request, userID string, remember bool) error {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		if err := m.SessionService.DeleteSession(c.Value); err != nil {
+			return err
+		}
+	}
+	if _, err := m.Start(w, r, userID); err != nil {
+		return err
+	}
+	if _, err := newCSRFToken(w, m.SecureCookie); err != nil {
+		return err
+	}
+	if remember {
+		return m.Remember(w, userID)
+	}
+	return nil
+}
+
+//Rotate replaces the session s with one with a new id, keeping its creation time, and updates the cookie.
+//It is done whenever the privileges of a session change, so an id leaked before the change is of no use after it.
+func (m *SessionManager) Rotate(w http.ResponseWriter, r *http.Request, s *finisafricae.Session) (*finisafricae.Session, error) {
+	id, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	ns := &finisafricae.Session{ID: id, UserID: s.UserID, Created: s.Created, LastSeen: time.Now().UTC(), IP: clientIP(r), UserAgent: userAgent(r)}
+	if err := m.SessionService.CreateSession(ns); err != nil {
+		return nil, err
+	}
+	if err := m.SessionService.DeleteSession(s.ID); err != nil {
+		return nil, err
+	}
+	m.setCookie(w, ns)
+	return ns, nil
+}
+
+//End deletes the session s and clears the session cookie
+func (m *SessionManager) End(w http.ResponseWriter, s *finisafricae.Session) error {
+	m.clearCookie(w)
+	return m.SessionService.DeleteSession(s.ID)
+}
+
+//Logout ends the session s of the user logging out with r, forgets a remembered login and replaces the CSRF token,
+//so nothing the client held while logged in is of use after
+func (m *SessionManager) Logout(w http.ResponseWriter, r *http.Request, s *finisafricae.Session) error {
+	if err := m.End(w, s); err != nil {
+		return err
+	}
+	if err := m.Forget(w, r); err != nil {
+		return err
+	}
+	_, err := newCSRFToken(w, m.SecureCookie)
+	return err
+}
+
+//EndUser deletes all sessions and remembered logins of the user, logging them out everywhere, and clears the cookies of r
+func (m *SessionManager) EndUser(w http.ResponseWriter, r *http.Request, userID string) error {
+	if err := m.LogoutUser(userID); err != nil {
+		return err
+	}
+	if _, err := r.Cookie(sessionCookie); err == nil {
+		m.clearCookie(w)
+	}
+	return m.ForgetUser(w, r, userID)
+}
+
+//LogoutUser deletes all sessions and remembered logins of the user without touching the cookies of the request,
+//for logging out someone else
+func (m *SessionManager) LogoutUser(userID string) error {
+	ses, err := m.SessionService.SessionsForUser(userID)
+	if err != nil {
+		return err
+	}
+	for _, s := range ses {
+		if err := m.SessionService.DeleteSession(s.ID); err != nil {
+			return err
+		}
+	}
+	if m.PersistentTokenService == nil {
+		return nil
+	}
+	return m.PersistentTokenService.DeletePersistentTokensForUser(userID)
+}
+
+//Impersonate replaces the session s of an admin with a session of the user with the given id, in which the admin acts as the user
+func (m *SessionManager) Impersonate(w http.ResponseWriter, r *http.Request, s *finisafricae.Session, userID string) error {
+	ns, err := m.Start(w, r, userID)
+	if err != nil {
+		return err
+	}
+	ns.Impersonator = s.UserID
+	if err := m.SessionService.UpdateSession(ns); err != nil {
+		return err
+	}
+	return m.SessionService.DeleteSession(s.ID)
+}
+
+//StopImpersonating replaces the session s in which an admin acts as another user with a session of the admin
+func (m *SessionManager) StopImpersonating(w http.ResponseWriter, r *http.Request, s *finisafricae.Session) error {
+	if _, err := m.Start(w, r, s.Impersonator); err != nil {
+		return err
+	}
+	return m.SessionService.DeleteSession(s.ID)
+}
+
+//Expired returns true if s has been idle for too long or has reached its absolute timeout
+func (m *SessionManager) Expired(s *finisafricae.Session) bool {
+	now := time.Now()
+	return now.Sub(s.LastSeen) > m.IdleTimeout || now.Sub(s.Created) > m.AbsoluteTimeout
+}
+
+func (m *SessionManager) setCookie(w http.ResponseWriter, s *finisafricae.Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    s.ID,
+		Path:     "/",
+		Expires:  s.Created.Add(m.AbsoluteTimeout),
+		HttpOnly: true,
+		Secure:   m.SecureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+//clearCookie tells the browser to delete the session cookie. Path and flags must match those of setCookie.
+func (m *SessionManager) clearCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   m.SecureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+//clientIP returns the IP address of the client of r. The server is expected to be reached directly,
+//so X-Forwarded-For and similar headers, which clients can set at will, are ignored.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+//userAgent returns the User-Agent of r, cut to fit the session table. Bytes that aren't UTF-8, such as the start of a
+//character cut in two, are left out, as MySQL refuses them.
+func userAgent(r *http.Request) string {
+	ua := r.UserAgent()
+	if len(ua) > 255 {
+		ua = ua[:255]
+	}
+	return strings.ToValidUTF8(ua, "")
+}
+
+//newToken returns 256 random bits, base64 encoded
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,24 @@
+package http
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestUserAgent(t *testing.T) {
+	for ua, want := range map[string]string{
+		"Mozilla/5.0":                  "Mozilla/5.0",
+		strings.Repeat("a", 300):       strings.Repeat("a", 255),
+		strings.Repeat("a", 254) + "é": strings.Repeat("a", 254),
+		"Bad \xff agent":               "Bad  agent",
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("User-Agent", ua)
+		got := userAgent(r)
+		if got != want || !utf8.ValidString(got) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//ShareHandler shows how the library of the user is published. POST changes it according to action: "publish" saves the fields
+//shown and whether the library is at a link and on the profile of the user, "link" replaces the link with a new one,
+//"revoke" removes the link and "unpublish" stops publishing the library.
+type ShareHandler struct {
+	PublicLibraryService finisafricae.PublicLibraryService
+	Audit                *Auditor
+	//BaseURL is the address users reach the server at, which links start with
+	BaseURL   string
+	Templates Templates
+}
+
+//sharePage is the data of share.gohtml. Library is nil if the library isn't published. Link is set right after it is made,
+//as only the hash of its token is stored.
+type sharePage struct {
+	Library *finisafricae.PublicLibrary
+	Fields  []string
+	Link    string
+	Message string
+}
+
+func (h *ShareHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := finisafricae.UserFromContext(r.Context())
+	l, err := h.PublicLibraryService.PublicLibrary(u.ID)
+	if errors.Is(err, finisafricae.ErrNotFound) {
+		l = nil
+	} else if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	p := sharePage{Library: l, Fields: finisafricae.BookFields}
+	if r.Method == "POST" {
+		if l == nil {
+			l = &finisafricae.PublicLibrary{UserID: u.ID}
+		}
+		var token, detail string
+		switch r.FormValue("action") {
+		case "publish":
+			l.Fields, l.OnProfile = r.Form["field"], r.FormValue("profile") != ""
+			if r.FormValue("link") == "" {
+				l.Hash = ""
+			} else if l.Hash == "" {
+				token, err = h.newLink(l)
+			}
+			detail = describeLibrary(l)
+		case "link":
+			token, err = h.newLink(l)
+			detail = "new link"
+		case "revoke":
+			l.Hash, detail = "", "link revoked"
+		case "unpublish":
+			l.Hash, l.OnProfile, detail = "", false, "unpublished"
+		default:
+			err = finisafricae.Errorf(finisafricae.ErrInvalid, "Unknown action.")
+		}
+		if err == nil {
+			err = h.save(l)
+		}
+		if ErrorStatus(err) == http.StatusBadRequest {
+			w.WriteHeader(http.StatusBadRequest)
+			p.Message = finisafricae.ErrorMessage(err)
+			render(w, r, h.Templates, "share.gohtml", p)
+			return
+		} else if err != nil {
+			renderError(w, r, h.Templates, err)
+			return
+		}
+		h.Audit.Record(r, &finisafricae.AuditEvent{Type: finisafricae.AuditShare, UserID: u.ID, Detail: detail})
+		if token == "" {
+			http.Redirect(w, r, "/share", http.StatusSeeOther)
+			return
+		}
+		p.Library, p.Link = l, h.BaseURL+"/library/"+token
+	}
+	render(w, r, h.Templates, "share.gohtml", p)
+}
+
+//newLink gives l a new link and returns its token. Any old link stops working once l is saved.
+func (h *ShareHandler) newLink(l *finisafricae.PublicLibrary) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	l.Hash, l.Created = hashToken(token), time.Now().UTC()
+	return token, nil
+}
+
+//save publishes l, or stops publishing it if it is neither at a link nor on the profile
+func (h *ShareHandler) save(l *finisafricae.PublicLibrary) error {
+	if l.Hash == "" && !l.OnProfile {
+		return h.PublicLibraryService.DeletePublicLibrary(l.UserID)
+	}
+	if l.Created.IsZero() {
+		l.Created = time.Now().UTC()
+	}
+	return h.PublicLibraryService.SavePublicLibrary(l)
+}
+
+//describeLibrary describes how l is published, for the audit log
+func describeLibrary(l *finisafricae.PublicLibrary) string {
+	var where []string
+	if l.Hash != "" {
+		where = append(where, "link")
+	}
+	if l.OnProfile {
+		where = append(where, "profile")
+	}
+	if len(where) == 0 {
+		return "unpublished"
+	}
+	d := "published at " + strings.Join(where, " and ")
+	if len(l.Fields) > 0 {
+		d += " showing " + strings.Join(l.Fields, ", ")
+	}
+	return d
+}
+
+//LibraryHandler serves the public library with the link of /library/{token} to anyone, logged in or not
+type LibraryHandler struct {
+	PublicLibraryService finisafricae.PublicLibraryService
+	UserService          finisafricae.UserService
+	BookService          finisafricae.BookService
+	Templates            Templates
+}
+
+//libraryPage is the data of library.gohtml and of the publicbooks template
+type libraryPage struct {
+	Uname   string
+	Library *finisafricae.PublicLibrary
+	Books   []*finisafricae.Book
+}
+
+func (h *LibraryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	//The token is in the URL, which must not be sent to other sites in the Referer header
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	token := strings.TrimPrefix(r.URL.Path, "/library/")
+	l, err := h.PublicLibraryService.PublicLibraryFromHash(hashToken(token))
+	if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	u, err := h.UserService.User(l.UserID)
+	if err == nil && (u.Disabled || !u.DeleteAt.IsZero()) {
+		//Disabled accounts and accounts being deleted are hidden
+		err = finisafricae.Errorf(finisafricae.ErrNotFound, "The link is not to a published library.")
+	}
+	if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	bs, err := h.BookService.Books(u.ID)
+	if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	render(w, r, h.Templates, "library.gohtml", libraryPage{Uname: u.Uname, Library: l, Books: bs})
+}
@@ -0,0 +1,65 @@
+package http
+
+import (
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+)
+
+//Templates executes the named html templates. *template.Template satisfies it.
+type Templates interface {
+	ExecuteTemplate(w io.Writer, name string, data interface{}) error
+}
+
+//ParseTemplates parses the *.gohtml templates of each layer in turn. A template in a later layer replaces
+//the template of the same name in earlier layers, so a theme only needs to contain the templates it changes.
+func ParseTemplates(layers ...fs.FS) (*template.Template, error) {
+	t := template.New("")
+	for _, l := range layers {
+		ms, err := fs.Glob(l, "*.gohtml")
+		if err != nil {
+			return nil, err
+		}
+		if len(ms) == 0 {
+			continue
+		}
+		if t, err = t.ParseFS(l, ms...); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+//ReloadingTemplates parses its layers again on every execution, so templates can be edited without restarting the server.
+//It is meant for development.
+type ReloadingTemplates []fs.FS
+
+//ExecuteTemplate parses the templates and executes the named one
+func (t ReloadingTemplates) ExecuteTemplate(w io.Writer, name string, data interface{}) error {
+	tt, err := ParseTemplates(t...)
+	if err != nil {
+		return err
+	}
+	return tt.ExecuteTemplate(w, name, data)
+}
+
+//StaticHandler serves the files of the layers, a file in a later layer taking precedence over one of the same name in earlier layers.
+//It is mounted under /static/.
+func StaticHandler(layers ...fs.FS) http.Handler {
+	return http.StripPrefix("/static/", http.FileServer(http.FS(overlay(layers))))
+}
+
+//overlay is a file system whose later layers shadow the earlier ones
+type overlay []fs.FS
+
+func (o overlay) Open(name string) (fs.File, error) {
+	err := error(&fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist})
+	for i := len(o) - 1; i >= 0; i-- {
+		var f fs.File
+		if f, err = o[i].Open(name); err == nil {
+			return f, nil
+		}
+	}
+	return nil, err
+}
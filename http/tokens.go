@@ -0,0 +1,104 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+//apiTokenPrefix starts every API token, so leaked tokens are easy to search for
+const apiTokenPrefix = "fa_"
+
+//TokensHandler lists the API tokens of the user on GET. POST creates a token with the name and scope of the form,
+//or revokes the token with the id of the form if action is "revoke".
+type TokensHandler struct {
+	APITokenService finisafricae.APITokenService
+	Templates       Templates
+}
+
+//tokensPage is the data of tokens.gohtml. Token is set right after it is created, as it can't be shown later.
+type tokensPage struct {
+	Message string
+	Token   string
+	Tokens  []*finisafricae.APIToken
+}
+
+func (h *TokensHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := finisafricae.UserFromContext(r.Context())
+	var p tokensPage
+	if r.Method == "POST" {
+		switch r.FormValue("action") {
+		case "revoke":
+			if err := h.revoke(u, r.FormValue("id")); err != nil {
+				renderError(w, r, h.Templates, err)
+				return
+			}
+			http.Redirect(w, r, "/tokens", http.StatusSeeOther)
+			return
+		default:
+			token, err := h.create(u, r.FormValue("name"), r.FormValue("scope"))
+			if err != nil && ErrorStatus(err) == http.StatusBadRequest {
+				w.WriteHeader(http.StatusBadRequest)
+				p.Message = finisafricae.ErrorMessage(err)
+			} else if err != nil {
+				renderError(w, r, h.Templates, err)
+				return
+			}
+			p.Token = token
+		}
+	}
+	ts, err := h.APITokenService.APITokensForUser(u.ID)
+	if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	p.Tokens = ts
+	render(w, r, h.Templates, "tokens.gohtml", p)
+}
+
+//create stores a new API token of u and returns it
+func (h *TokensHandler) create(u *finisafricae.User, name, scope string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 64 {
+		return "", finisafricae.Errorf(finisafricae.ErrInvalid, "The token must have a name of at most 64 characters.")
+	}
+	if scope != finisafricae.ScopeRead && scope != finisafricae.ScopeReadWrite {
+		return "", finisafricae.Errorf(finisafricae.ErrInvalid, "Choose whether the token can change your library.")
+	}
+	id, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	token = apiTokenPrefix + token
+	t := &finisafricae.APIToken{
+		ID:      id.String(),
+		UserID:  u.ID,
+		Name:    name,
+		Scope:   scope,
+		Hash:    hashToken(token),
+		Created: time.Now().UTC(),
+	}
+	return token, h.APITokenService.CreateAPIToken(t)
+}
+
+//revoke deletes the API token of u with the given id. Only the tokens of u can be revoked.
+func (h *TokensHandler) revoke(u *finisafricae.User, id string) error {
+	ts, err := h.APITokenService.APITokensForUser(u.ID)
+	if err != nil {
+		return err
+	}
+	for _, t := range ts {
+		if t.ID == id {
+			return h.APITokenService.DeleteAPIToken(t.ID)
+		}
+	}
+	return finisafricae.Errorf(finisafricae.ErrNotFound, "The API token was not found.")
+}
@@ -0,0 +1,333 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+	"github.com/madskrogh/finisafricae/lockout"
+	"github.com/madskrogh/finisafricae/totp"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	//issuer names the application in authenticator apps
+	issuer = "finis Africae"
+	//recoveryCodes is how many recovery codes a user has
+	recoveryCodes = 10
+	//totpLoginFor is how long users have to enter their code after their password
+	totpLoginFor = 5 * time.Minute
+	//totpSetupFor is how long users have to enter a code of their app after adding the secret to it
+	totpSetupFor = 30 * time.Minute
+)
+
+//TOTPHandler turns two-factor authentication with an authenticator app on and off, and replaces recovery codes
+type TOTPHandler struct {
+	UserService         finisafricae.UserService
+	OneTimeTokenService finisafricae.OneTimeTokenService
+	Sessions            *SessionManager
+	Passwords           finisafricae.PasswordHasher
+	Templates           Templates
+}
+
+//totpPage is the data of totp.gohtml. Setup, the token of the one time token holding Secret, URI and QR are set while
+//enrolling, RecoveryCodes right after they are made.
+type totpPage struct {
+	Message       string
+	Enabled       bool
+	Setup         string
+	Secret        string
+	URI           string
+	QR            template.URL
+	RecoveryCodes []string
+}
+
+func (h *TOTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := finisafricae.UserFromContext(r.Context())
+	if r.Method != "POST" {
+		if u.TOTPSecret != "" {
+			render(w, r, h.Templates, "totp.gohtml", totpPage{Enabled: true})
+			return
+		}
+		h.enroll(w, r, u, "")
+		return
+	}
+
+	switch r.FormValue("action") {
+	case "enable":
+		if u.TOTPSecret != "" {
+			http.Redirect(w, r, "/totp", http.StatusSeeOther)
+			return
+		}
+		//The secret is kept on the server until the user proves their app has it by entering a code, so it can't be swapped
+		setup := r.FormValue("setup")
+		t, err := h.OneTimeTokenService.OneTimeToken(hashToken(setup), finisafricae.PurposeTOTPSetup)
+		if err == nil && t.UserID != u.ID {
+			err = finisafricae.Errorf(finisafricae.ErrNotFound, "The setup was not found.")
+		}
+		if errors.Is(err, finisafricae.ErrNotFound) {
+			w.WriteHeader(http.StatusBadRequest)
+			h.enroll(w, r, u, "The setup has expired. Add the new key to your app and try again.")
+			return
+		} else if err != nil {
+			renderError(w, r, h.Templates, err)
+			return
+		}
+		step, ok := totp.Validate(t.Data, r.FormValue("code"), time.Now(), 0)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			h.enrollment(w, r, u, t.Data, setup, "Wrong code. Check the time of your device and try again.")
+			return
+		}
+		if _, err := h.OneTimeTokenService.UseOneTimeToken(t.Hash, finisafricae.PurposeTOTPSetup); err != nil {
+			renderError(w, r, h.Templates, err)
+			return
+		}
+		u.TOTPSecret, u.TOTPStep = t.Data, step
+		if err := h.UserService.UpdateUser(u); err != nil {
+			renderError(w, r, h.Templates, err)
+			return
+		}
+		codes, err := newRecoveryCodes(h.OneTimeTokenService, u.ID)
+		if err != nil {
+			renderError(w, r, h.Templates, err)
+			return
+		}
+		//The session got more privileged, so it gets a new id
+		if _, err := h.Sessions.Rotate(w, r, finisafricae.SessionFromContext(r.Context())); err != nil {
+			renderError(w, r, h.Templates, err)
+			return
+		}
+		render(w, r, h.Templates, "totp.gohtml", totpPage{Message: "Two-factor authentication is on.", Enabled: true, RecoveryCodes: codes})
+
+	case "disable", "recovery":
+		if u.TOTPSecret == "" {
+			http.Redirect(w, r, "/totp", http.StatusSeeOther)
+			return
+		}
+		if err := h.Passwords.Compare(u.Password, r.FormValue("password")); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			render(w, r, h.Templates, "totp.gohtml", totpPage{Message: "Wrong password.", Enabled: true})
+			return
+		}
+		if r.FormValue("action") == "recovery" {
+			codes, err := newRecoveryCodes(h.OneTimeTokenService, u.ID)
+			if err != nil {
+				renderError(w, r, h.Templates, err)
+				return
+			}
+			render(w, r, h.Templates, "totp.gohtml", totpPage{Message: "Your old recovery codes no longer work.", Enabled: true, RecoveryCodes: codes})
+			return
+		}
+		u.TOTPSecret, u.TOTPStep = "", 0
+		if err := h.UserService.UpdateUser(u); err != nil {
+			renderError(w, r, h.Templates, err)
+			return
+		}
+		if err := h.OneTimeTokenService.DeleteOneTimeTokensForUser(u.ID, finisafricae.PurposeRecoveryCode); err != nil {
+			renderError(w, r, h.Templates, err)
+			return
+		}
+		render(w, r, h.Templates, "user.gohtml", "Two-factor authentication is off.")
+
+	default:
+		http.Redirect(w, r, "/totp", http.StatusSeeOther)
+	}
+}
+
+//enroll renders the page for adding a new secret to an authenticator app. The secret is kept in a one time token, replacing
+//that of an earlier enrollment of the user.
+func (h *TOTPHandler) enroll(w http.ResponseWriter, r *http.Request, u *finisafricae.User, message string) {
+	secret, err := totp.NewSecret()
+	if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	setup, err := newToken()
+	if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	if err := h.OneTimeTokenService.DeleteOneTimeTokensForUser(u.ID, finisafricae.PurposeTOTPSetup); err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	t := &finisafricae.OneTimeToken{Hash: hashToken(setup), UserID: u.ID, Purpose: finisafricae.PurposeTOTPSetup, Expires: time.Now().Add(totpSetupFor).UTC(), Data: secret}
+	if err := h.OneTimeTokenService.CreateOneTimeToken(t); err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	h.enrollment(w, r, u, secret, setup, message)
+}
+
+//enrollment renders the page for adding secret, held by the setup token, to an authenticator app
+func (h *TOTPHandler) enrollment(w http.ResponseWriter, r *http.Request, u *finisafricae.User, secret, setup, message string) {
+	uri := totp.URI(issuer, u.Email, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	qr := template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+	render(w, r, h.Templates, "totp.gohtml", totpPage{Message: message, Setup: setup, Secret: secret, URI: uri, QR: qr})
+}
+
+//newRecoveryCodes replaces the recovery codes of the user with new ones and returns them. Only their hashes are stored,
+//so they can only be shown now.
+func newRecoveryCodes(s finisafricae.OneTimeTokenService, userID string) ([]string, error) {
+	if err := s.DeleteOneTimeTokensForUser(userID, finisafricae.PurposeRecoveryCode); err != nil {
+		return nil, err
+	}
+	codes := make([]string, recoveryCodes)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		c := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		codes[i] = c[:4] + "-" + c[4:]
+		t := &finisafricae.OneTimeToken{
+			Hash:    hashToken(normalizeRecoveryCode(codes[i])),
+			UserID:  userID,
+			Purpose: finisafricae.PurposeRecoveryCode,
+			//Recovery codes work until they are used or replaced
+			Expires: time.Now().AddDate(100, 0, 0).UTC(),
+		}
+		if err := s.CreateOneTimeToken(t); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+//normalizeRecoveryCode returns code without the dash, spaces and capitals users may type
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+//totpLoginPage is the data of totplogin.gohtml
+type totpLoginPage struct {
+	Token string
+}
+
+//startTOTPLogin renders the second login step for the user u, who has entered the right password.
+//The page holds a token standing in for the password.
+func startTOTPLogin(w http.ResponseWriter, r *http.Request, t Templates, s finisafricae.OneTimeTokenService, u *finisafricae.User, remember bool) {
+	token, err := newToken()
+	if err != nil {
+		renderError(w, r, t, err)
+		return
+	}
+	ot := &finisafricae.OneTimeToken{
+		Hash:    hashToken(token),
+		UserID:  u.ID,
+		Purpose: finisafricae.PurposeLogin,
+		Expires: time.Now().Add(totpLoginFor).UTC(),
+	}
+	if remember {
+		ot.Data = "remember"
+	}
+	if err := s.CreateOneTimeToken(ot); err != nil {
+		renderError(w, r, t, err)
+		return
+	}
+	render(w, r, t, "totplogin.gohtml", totpLoginPage{Token: token})
+}
+
+//TOTPLoginHandler is the second login step of users with two-factor authentication. It logs the user in if the code is
+//from their authenticator app or one of their recovery codes.
+type TOTPLoginHandler struct {
+	UserService         finisafricae.UserService
+	OneTimeTokenService finisafricae.OneTimeTokenService
+	Sessions            *SessionManager
+	Lockout             *lockout.Limiter
+	Audit               *Auditor
+	Templates           Templates
+}
+
+func (h *TOTPLoginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	//Each token allows one try, so guessing codes takes a password for every guess
+	t, err := h.OneTimeTokenService.UseOneTimeToken(hashToken(r.FormValue("token")), finisafricae.PurposeLogin)
+	if errors.Is(err, finisafricae.ErrNotFound) {
+		w.WriteHeader(http.StatusUnauthorized)
+		render(w, r, h.Templates, "index.gohtml", "The login has expired. Login again.")
+		return
+	} else if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	u, err := h.UserService.User(t.UserID)
+	if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	ok, err := h.checkCode(u, r.FormValue("code"))
+	if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	if !ok {
+		//Wrong codes count as failed logins, so codes can't be guessed by someone who knows the password
+		h.Audit.Record(r, &finisafricae.AuditEvent{Type: finisafricae.AuditLoginFailed, UserID: u.ID, Detail: "Wrong two-factor code"})
+		if err := h.Lockout.Fail(u.Email, clientIP(r)); err != nil {
+			renderError(w, r, h.Templates, err)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		render(w, r, h.Templates, "index.gohtml", "Wrong code. Login again.")
+		return
+	}
+	if err := h.Lockout.Succeed(u.Email); err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	if u.Disabled {
+		//The account was disabled between the two steps
+		w.WriteHeader(http.StatusForbidden)
+		render(w, r, h.Templates, "index.gohtml", finisafricae.ErrorMessage(errDisabled))
+		return
+	}
+	if err := keepAccount(h.UserService, u); err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	if err := h.Sessions.Login(w, r, u.ID, t.Data == "remember"); err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	h.Audit.Record(r, &finisafricae.AuditEvent{Type: finisafricae.AuditLogin, UserID: u.ID, Detail: "Two-factor code"})
+	http.Redirect(w, r, "/home", http.StatusSeeOther)
+}
+
+//checkCode returns true if code is a current code of the authenticator app of u or an unused recovery code of u, and uses it up
+func (h *TOTPLoginHandler) checkCode(u *finisafricae.User, code string) (bool, error) {
+	if step, ok := totp.Validate(u.TOTPSecret, code, time.Now(), u.TOTPStep); ok {
+		u.TOTPStep = step
+		return true, h.UserService.UpdateUser(u)
+	}
+	hash := hashToken(normalizeRecoveryCode(code))
+	//The code is checked to be the user's before it is used, so a code of another user can't be used up
+	t, err := h.OneTimeTokenService.OneTimeToken(hash, finisafricae.PurposeRecoveryCode)
+	if errors.Is(err, finisafricae.ErrNotFound) || (err == nil && t.UserID != u.ID) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if _, err := h.OneTimeTokenService.UseOneTimeToken(hash, finisafricae.PurposeRecoveryCode); errors.Is(err, finisafricae.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
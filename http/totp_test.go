@@ -0,0 +1,142 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+	"github.com/madskrogh/finisafricae/totp"
+)
+
+//rfcSecret is the key of the test vectors of RFC 6238, base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+//totpTest is a TOTPHandler of a logged in user
+type totpTest struct {
+	t      *testing.T
+	h      *TOTPHandler
+	users  *memUsers
+	tokens *memTokens
+	ses    *finisafricae.Session
+}
+
+func newTOTPTest(t *testing.T) *totpTest {
+	u := &finisafricae.User{ID: "r", Uname: "reader", Email: "reader@example.com", Password: "plain:password", Verified: true, Role: finisafricae.RoleMember}
+	sessions := &memSessions{}
+	ses := &finisafricae.Session{ID: "s", UserID: u.ID, Created: time.Now(), LastSeen: time.Now()}
+	if err := sessions.CreateSession(ses); err != nil {
+		t.Fatal(err)
+	}
+	tt := &totpTest{t: t, users: &memUsers{users: []*finisafricae.User{u}}, tokens: &memTokens{}, ses: ses}
+	tt.h = &TOTPHandler{
+		UserService:         tt.users,
+		OneTimeTokenService: tt.tokens,
+		Sessions:            &SessionManager{SessionService: sessions, IdleTimeout: time.Hour, AbsoluteTimeout: time.Hour},
+		Passwords:           plainHasher{},
+		Templates:           testTemplates(t),
+	}
+	return tt
+}
+
+//do serves a request of the user with the form values v, or a GET if v is nil
+func (tt *totpTest) do(v url.Values) *httptest.ResponseRecorder {
+	tt.t.Helper()
+	u, err := tt.users.User("r")
+	if err != nil {
+		tt.t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/totp", nil)
+	if v != nil {
+		r = httptest.NewRequest("POST", "/totp", strings.NewReader(v.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	ctx := finisafricae.NewContextWithSession(finisafricae.NewContextWithUser(r.Context(), u), tt.ses)
+	w := httptest.NewRecorder()
+	tt.h.ServeHTTP(w, r.WithContext(ctx))
+	return w
+}
+
+//setup returns the secret of the enrollment of the user and the token holding it, which are on the page of w
+func (tt *totpTest) setup(w *httptest.ResponseRecorder) (string, string) {
+	tt.t.Helper()
+	var secret string
+	for _, t := range tt.tokens.tokens {
+		if t.Purpose == finisafricae.PurposeTOTPSetup {
+			secret = t.Data
+		}
+	}
+	body := w.Body.String()
+	i := strings.Index(body, `name="setup" value="`)
+	if secret == "" || i < 0 || !strings.Contains(body, secret) {
+		tt.t.Fatalf("no enrollment on the page: %s", body)
+	}
+	setup := body[i+len(`name="setup" value="`):]
+	return secret, setup[:strings.IndexByte(setup, '"')]
+}
+
+//enabled returns the secret of the user, which is empty while two-factor authentication is off
+func (tt *totpTest) enabled() string {
+	u, _ := tt.users.User("r")
+	return u.TOTPSecret
+}
+
+func TestTOTPEnable(t *testing.T) {
+	tt := newTOTPTest(t)
+	secret, setup := tt.setup(tt.do(nil))
+	if w := tt.do(url.Values{"action": {"enable"}, "setup": {setup}, "code": {"000000"}}); w.Code != http.StatusBadRequest || tt.enabled() != "" {
+		t.Fatalf("wrong code: got status %d", w.Code)
+	}
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := tt.do(url.Values{"action": {"enable"}, "setup": {setup}, "code": {code}}); w.Code != http.StatusOK || tt.enabled() != secret {
+		t.Fatalf("got status %d and secret %q, want %q", w.Code, tt.enabled(), secret)
+	}
+	for _, tok := range tt.tokens.tokens {
+		if tok.Purpose == finisafricae.PurposeTOTPSetup {
+			t.Error("the setup token was not used up")
+		}
+	}
+}
+
+func TestTOTPEnableForgedSecret(t *testing.T) {
+	//A secret of the attacker's choosing, such as an empty one whose codes anyone can compute, is ignored in the form
+	forged, err := totp.Code(rfcSecret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, v := range map[string]url.Values{
+		"empty secret in the form":  {"secret": {""}, "code": {"000000"}},
+		"forged secret in the form": {"secret": {rfcSecret}, "code": {forged}},
+		"no setup":                  {"setup": {""}, "code": {"000000"}},
+		"made up setup":             {"setup": {"forged"}, "code": {"000000"}},
+	} {
+		tt := newTOTPTest(t)
+		tt.do(nil)
+		v.Set("action", "enable")
+		if w := tt.do(v); w.Code != http.StatusBadRequest || tt.enabled() != "" {
+			t.Errorf("%s: got status %d and secret %q", name, w.Code, tt.enabled())
+		}
+	}
+}
+
+func TestTOTPEnableOtherUsersSetup(t *testing.T) {
+	tt := newTOTPTest(t)
+	secret, err := totp.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tt.tokens.tokens = append(tt.tokens.tokens, &finisafricae.OneTimeToken{Hash: hashToken("other"), UserID: "o", Purpose: finisafricae.PurposeTOTPSetup, Expires: time.Now().Add(time.Hour), Data: secret})
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := tt.do(url.Values{"action": {"enable"}, "setup": {"other"}, "code": {code}}); w.Code != http.StatusBadRequest || tt.enabled() != "" {
+		t.Errorf("got status %d and secret %q", w.Code, tt.enabled())
+	}
+}
@@ -0,0 +1,114 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//UpdateUsernameHandler changes the username of the user to the one of the form. The old username stays theirs, so
+//nobody else can take it and links to their profile by it keep working.
+type UpdateUsernameHandler struct {
+	UserService finisafricae.UserService
+	Passwords   finisafricae.PasswordHasher
+	Audit       *Auditor
+	Templates   Templates
+}
+
+func (h *UpdateUsernameHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/user", http.StatusSeeOther)
+		return
+	}
+	u := finisafricae.UserFromContext(r.Context())
+	if err := h.Passwords.Compare(u.Password, r.FormValue("password")); err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		render(w, r, h.Templates, "user.gohtml", "Wrong password.")
+		return
+	}
+	uname := strings.TrimSpace(r.FormValue("uname"))
+	if err := finisafricae.ValidateUsername(uname); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render(w, r, h.Templates, "user.gohtml", finisafricae.ErrorMessage(err))
+		return
+	}
+	if uname == u.Uname {
+		render(w, r, h.Templates, "user.gohtml", "Your username is already "+uname+".")
+		return
+	}
+	old := u.Uname
+	u.Uname = uname
+	if err := h.UserService.UpdateUser(u); errors.Is(err, finisafricae.ErrConflict) {
+		u.Uname = old
+		w.WriteHeader(http.StatusConflict)
+		render(w, r, h.Templates, "user.gohtml", finisafricae.ErrorMessage(err))
+		return
+	} else if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	h.Audit.Record(r, &finisafricae.AuditEvent{Type: finisafricae.AuditUsernameChange, UserID: u.ID, Detail: old + " to " + uname})
+	render(w, r, h.Templates, "user.gohtml", "Your username is now "+uname+".")
+}
+
+//ProfileHandler serves the public profile of the user with the username of /u/{username}, with their library if they
+//publish it there. Former usernames redirect to the current one.
+type ProfileHandler struct {
+	UserService          finisafricae.UserService
+	PublicLibraryService finisafricae.PublicLibraryService
+	BookService          finisafricae.BookService
+	Templates            Templates
+}
+
+//profilePage is the data of profile.gohtml and of the publicbooks template
+type profilePage struct {
+	Uname string
+	//Former are the former usernames of the user, only shown to themselves
+	Former []*finisafricae.UsernameChange
+	//Library is nil unless the user publishes their library on the profile
+	Library *finisafricae.PublicLibrary
+	Books   []*finisafricae.Book
+}
+
+func (h *ProfileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	notFound := finisafricae.Errorf(finisafricae.ErrNotFound, "No user with this username exists.")
+	uname := strings.TrimPrefix(r.URL.Path, "/u/")
+	if uname == "" || strings.Contains(uname, "/") {
+		renderError(w, r, h.Templates, notFound)
+		return
+	}
+	u, err := h.UserService.UserFromUsername(uname)
+	if err == nil && (u.Disabled || !u.DeleteAt.IsZero()) {
+		//Disabled accounts and accounts being deleted are hidden
+		err = notFound
+	}
+	if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	if u.Uname != uname {
+		http.Redirect(w, r, "/u/"+url.PathEscape(u.Uname), http.StatusMovedPermanently)
+		return
+	}
+	p := profilePage{Uname: u.Uname}
+	if l, err := h.PublicLibraryService.PublicLibrary(u.ID); err == nil && l.OnProfile {
+		if p.Books, err = h.BookService.Books(u.ID); err != nil {
+			renderError(w, r, h.Templates, err)
+			return
+		}
+		p.Library = l
+	} else if err != nil && !errors.Is(err, finisafricae.ErrNotFound) {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	if cur := finisafricae.UserFromContext(r.Context()); cur != nil && cur.ID == u.ID {
+		if p.Former, err = h.UserService.UsernameHistory(u.ID); err != nil {
+			renderError(w, r, h.Templates, err)
+			return
+		}
+	}
+	render(w, r, h.Templates, "profile.gohtml", p)
+}
@@ -0,0 +1,110 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//sendVerification mails a link confirming within d that email belongs to the user u. If email is not the current email of u,
+//following the link changes it.
+func sendVerification(l *LinkMailer, u *finisafricae.User, email string, d time.Duration) error {
+	t := &finisafricae.OneTimeToken{UserID: u.ID, Purpose: finisafricae.PurposeVerifyEmail}
+	if email != u.Email {
+		t.Purpose, t.Data = finisafricae.PurposeChangeEmail, email
+	}
+	body := "Follow the link below within " + d.String() + " to confirm that this is the email of your finis Africae account:\n\n%s\n\n" +
+		"If you didn't sign up or change your email, ignore this mail.\n"
+	return l.Send(email, t, d, "/verify", "Confirm your finis Africae email", body)
+}
+
+//VerifyEmailHandler confirms the email of the verification link it is reached by, changing the email of the user if the link was
+//for a new one. GET only asks the user to confirm, as mail scanners follow links and would use the token up.
+type VerifyEmailHandler struct {
+	UserService         finisafricae.UserService
+	OneTimeTokenService finisafricae.OneTimeTokenService
+	Sessions            *SessionManager
+	Audit               *Auditor
+	Templates           Templates
+}
+
+//verifyPage is the data of verify.gohtml
+type verifyPage struct {
+	Token string
+	Email string
+}
+
+func (h *VerifyEmailHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	//The token is in the URL, which must not be sent to other sites in the Referer header
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	token := r.FormValue("token")
+	t, err := h.token(token, r.Method == "POST")
+	if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	u, err := h.UserService.User(t.UserID)
+	if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	if r.Method != "POST" {
+		email := u.Email
+		if t.Data != "" {
+			email = t.Data
+		}
+		render(w, r, h.Templates, "verify.gohtml", verifyPage{Token: token, Email: email})
+		return
+	}
+	old := u.Email
+	if t.Data != "" {
+		u.Email = t.Data
+	}
+	u.Verified = true
+	err = h.UserService.UpdateUser(u)
+	if errors.Is(err, finisafricae.ErrConflict) {
+		//Someone else took the email after the link was sent
+		renderError(w, r, h.Templates, finisafricae.Errorf(finisafricae.ErrConflict, "Another user has taken this email since the link was sent."))
+		return
+	} else if err != nil {
+		renderError(w, r, h.Templates, err)
+		return
+	}
+	if u.Email != old {
+		h.Audit.Record(r, &finisafricae.AuditEvent{Type: finisafricae.AuditEmailChange, UserID: u.ID, Detail: old + " to " + u.Email})
+	}
+	cur := finisafricae.UserFromContext(r.Context())
+	if cur == nil || cur.ID != u.ID {
+		render(w, r, h.Templates, "index.gohtml", "Your email "+u.Email+" is confirmed. Login to continue.")
+		return
+	}
+	if t.Data != "" {
+		//The credentials changed, so the session gets a new id
+		if _, err := h.Sessions.Rotate(w, r, finisafricae.SessionFromContext(r.Context())); err != nil {
+			renderError(w, r, h.Templates, err)
+			return
+		}
+	}
+	//The page shows the user of the request, who is now out of date
+	r = r.WithContext(finisafricae.NewContextWithUser(r.Context(), u))
+	render(w, r, h.Templates, "user.gohtml", "Your email "+u.Email+" is confirmed.")
+}
+
+//token returns the email verification or change token, using it up if use is true
+func (h *VerifyEmailHandler) token(token string, use bool) (*finisafricae.OneTimeToken, error) {
+	var t *finisafricae.OneTimeToken
+	var err error
+	for _, purpose := range []string{finisafricae.PurposeVerifyEmail, finisafricae.PurposeChangeEmail} {
+		if use {
+			t, err = h.OneTimeTokenService.UseOneTimeToken(hashToken(token), purpose)
+		} else {
+			t, err = h.OneTimeTokenService.OneTimeToken(hashToken(token), purpose)
+		}
+		if !errors.Is(err, finisafricae.ErrNotFound) {
+			break
+		}
+	}
+	return t, err
+}
@@ -0,0 +1,84 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//linkToken returns the token of the link in the mail m
+func linkToken(t *testing.T, m *finisafricae.Mail) string {
+	t.Helper()
+	i := strings.Index(m.Body, "?token=")
+	if i < 0 {
+		t.Fatalf("no link in %q", m.Body)
+	}
+	v, err := url.ParseQuery(strings.Fields(m.Body[i+1:])[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v.Get("token")
+}
+
+func TestVerifyEmail(t *testing.T) {
+	us := &memUsers{users: []*finisafricae.User{{ID: "u1", Uname: "reader", Email: "old@example.com"}}}
+	ots := &memTokens{}
+	mailer := &memMailer{}
+	links := &LinkMailer{OneTimeTokenService: ots, Mailer: mailer, BaseURL: "https://example.com"}
+	h := &VerifyEmailHandler{UserService: us, OneTimeTokenService: ots, Sessions: &SessionManager{SessionService: &memSessions{}}, Templates: testTemplates(t)}
+
+	u, _ := us.User("u1")
+	if err := sendVerification(links, u, u.Email, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendVerification(links, u, "new@example.com", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if len(mailer.mails) != 2 || len(ots.tokens) != 2 {
+		t.Fatalf("got %d mails and %d tokens, want 2 of each: a link for a new email must not replace the one for the current", len(mailer.mails), len(ots.tokens))
+	}
+	verify, change := linkToken(t, mailer.mails[0]), linkToken(t, mailer.mails[1])
+
+	do := func(method, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/verify?"+url.Values{"token": {token}}.Encode(), nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	//Following the link, as mail scanners do, only asks for confirmation
+	for i := 0; i < 2; i++ {
+		if rec := do("GET", verify); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "old@example.com") {
+			t.Fatalf("GET: got status %d, body %s", rec.Code, rec.Body)
+		}
+	}
+	if u, _ := us.User("u1"); u.Verified {
+		t.Fatal("GET confirmed the email")
+	}
+	if rec := do("GET", change); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "new@example.com") {
+		t.Fatalf("GET: got status %d, body %s", rec.Code, rec.Body)
+	}
+
+	if rec := do("POST", verify); rec.Code != http.StatusOK {
+		t.Fatalf("POST: got status %d", rec.Code)
+	}
+	if u, _ := us.User("u1"); !u.Verified || u.Email != "old@example.com" {
+		t.Fatalf("got %+v, want the current email confirmed", u)
+	}
+	if rec := do("POST", verify); rec.Code != http.StatusNotFound {
+		t.Errorf("POST again: got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := do("POST", change); rec.Code != http.StatusOK {
+		t.Fatalf("POST: got status %d", rec.Code)
+	}
+	if u, _ := us.User("u1"); u.Email != "new@example.com" {
+		t.Errorf("got email %s, want the new one", u.Email)
+	}
+	if rec := do("GET", "unknown"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown token: got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
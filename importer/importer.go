@@ -0,0 +1,107 @@
+//Package importer reads books from files in the supported formats and adds them to libraries. It is shared by the
+//import page and the books import command, so both accept the same files and treat them the same.
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/madskrogh/finisafricae"
+	"github.com/madskrogh/finisafricae/epub"
+	"github.com/madskrogh/finisafricae/marc"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+//formats maps file extensions to formats
+var formats = map[string]string{
+	".xml":  "marcxml",
+	".mrc":  "marc",
+	".epub": "epub",
+	".opf":  "opf",
+}
+
+//Format returns the format of the named file guessed from its extension: marcxml, marc, epub or opf, or "" if the
+//extension is unknown
+func Format(name string) string {
+	return formats[strings.ToLower(path.Ext(name))]
+}
+
+//File is an open file to read books from. Both *os.File and multipart.File satisfy it.
+type File interface {
+	io.Reader
+	io.ReaderAt
+}
+
+//Read reads the books of the file f of the given size in the given format. If no format is given it is guessed from
+//the name of the file.
+func Read(f File, name string, size int64, format string) ([]*finisafricae.Book, error) {
+	if format == "" {
+		format = Format(name)
+	}
+	switch format {
+	case "marcxml":
+		return marc.ReadXML(f)
+	case "marc":
+		return marc.Read(f)
+	case "epub":
+		b, err := epub.Read(f, size)
+		if err != nil {
+			return nil, err
+		}
+		return []*finisafricae.Book{b}, nil
+	case "opf":
+		b, err := epub.ReadOPF(f)
+		if err != nil {
+			return nil, err
+		}
+		return []*finisafricae.Book{b}, nil
+	}
+	return nil, errors.New("unknown file format")
+}
+
+//Skipped is a book Import left out and why
+type Skipped struct {
+	//N is the position of the book among those imported, counting from one
+	N      int
+	Title  string
+	Reason string
+}
+
+//Import stores the books bs for the user and returns those it skipped: books without a title, with a title already
+//among the users books or with fields too long. If a book can't be stored for any other reason, the books stored
+//before it are deleted again, leaving the library as it was, and the error is returned.
+func Import(s finisafricae.BookService, userID string, bs []*finisafricae.Book) ([]Skipped, error) {
+	var skipped []Skipped
+	var created []string
+	for i, b := range bs {
+		bID, err := uuid.NewV4()
+		if err != nil {
+			return nil, undo(s, created, err)
+		}
+		b.ID = bID.String()
+		b.UserID = userID
+		err = s.CreateBook(b)
+		if errors.Is(err, finisafricae.ErrInvalid) || errors.Is(err, finisafricae.ErrConflict) {
+			skipped = append(skipped, Skipped{N: i + 1, Title: b.Title, Reason: finisafricae.ErrorMessage(err)})
+			continue
+		} else if err != nil {
+			return nil, undo(s, created, err)
+		}
+		created = append(created, b.ID)
+	}
+	return skipped, nil
+}
+
+//undo deletes the books with the given ids after the import failed with err, and returns err
+func undo(s finisafricae.BookService, ids []string, err error) error {
+	for _, id := range ids {
+		if derr := s.DeleteBook(id); derr != nil {
+			return fmt.Errorf("%v, and deleting the %d books imported before failed: %v", err, len(ids), derr)
+		}
+	}
+	return err
+}
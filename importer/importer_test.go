@@ -0,0 +1,83 @@
+package importer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//books is a BookService keeping books in memory, failing to create the book titled fail
+type books struct {
+	finisafricae.BookService
+	books map[string]*finisafricae.Book
+	fail  string
+}
+
+func (s *books) CreateBook(b *finisafricae.Book) error {
+	if err := b.Validate(); err != nil {
+		return err
+	}
+	if b.Title == s.fail {
+		return errors.New("connection lost")
+	}
+	for _, o := range s.books {
+		if o.UserID == b.UserID && o.Title == b.Title {
+			return finisafricae.Errorf(finisafricae.ErrConflict, "A book with this title already exists.")
+		}
+	}
+	s.books[b.ID] = b
+	return nil
+}
+
+func (s *books) DeleteBook(id string) error {
+	delete(s.books, id)
+	return nil
+}
+
+func TestImport(t *testing.T) {
+	s := &books{books: map[string]*finisafricae.Book{"1": {ID: "1", UserID: "u", Title: "Rose"}}}
+	skipped, err := Import(s, "u", []*finisafricae.Book{
+		{Title: "Rose"},
+		{Title: "Foucault's Pendulum", Author: "Eco"},
+		{Author: "Nobody"},
+		{Title: strings.Repeat("Long ", 100)},
+		{Title: "Baudolino"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.books) != 3 {
+		t.Errorf("the library has %d books, want 3", len(s.books))
+	}
+	var ns []int
+	for _, sk := range skipped {
+		ns = append(ns, sk.N)
+		if sk.Reason == "" {
+			t.Errorf("book %d was skipped for no reason", sk.N)
+		}
+	}
+	if len(ns) != 3 || ns[0] != 1 || ns[1] != 3 || ns[2] != 4 {
+		t.Errorf("skipped books %v, want [1 3 4]", ns)
+	}
+}
+
+func TestImportUndo(t *testing.T) {
+	s := &books{books: map[string]*finisafricae.Book{"1": {ID: "1", UserID: "u", Title: "Rose"}}, fail: "Baudolino"}
+	_, err := Import(s, "u", []*finisafricae.Book{{Title: "Foucault's Pendulum"}, {Title: "Rose"}, {Title: "Baudolino"}, {Title: "Numero zero"}})
+	if err == nil {
+		t.Fatal("the import succeeded")
+	}
+	if len(s.books) != 1 || s.books["1"] == nil {
+		t.Errorf("the library has %d books after the failed import, want only the one it had", len(s.books))
+	}
+}
+
+func TestFormat(t *testing.T) {
+	for name, want := range map[string]string{"records.XML": "marcxml", "a.mrc": "marc", "book.epub": "epub", "metadata.opf": "opf", "notes.txt": ""} {
+		if got := Format(name); got != want {
+			t.Errorf("Format(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package inmem
+
+import (
+	"sync"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//AuditService represents an in-memory implementation of the finisafricae.AuditService interface.
+//The zero value is ready to use.
+type AuditService struct {
+	mu     sync.Mutex
+	events []finisafricae.AuditEvent
+}
+
+//RecordAuditEvent appends a copy of e and sets its ID
+func (s *AuditService) RecordAuditEvent(e *finisafricae.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.ID = int64(len(s.events) + 1)
+	s.events = append(s.events, *e)
+	return nil
+}
+
+//AuditEvents returns copies of the events selected by f, newest first
+func (s *AuditService) AuditEvents(f finisafricae.AuditFilter) ([]*finisafricae.AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	es := make([]*finisafricae.AuditEvent, 0)
+	for i := len(s.events) - 1; i >= 0 && (f.Limit == 0 || len(es) < f.Limit); i-- {
+		e := s.events[i]
+		if f.UserID != "" && e.UserID != f.UserID || f.Type != "" && e.Type != f.Type ||
+			!f.From.IsZero() && e.Time.Before(f.From) || !f.To.IsZero() && !e.Time.Before(f.To) {
+			continue
+		}
+		es = append(es, &e)
+	}
+	return es, nil
+}
@@ -0,0 +1,3 @@
+//Package inmem implements services in memory. Their data is lost when the process exits and is not shared between
+//processes, so they suit single instance deployments, development and data that is cheap to lose.
+package inmem
@@ -0,0 +1,82 @@
+package inmem
+
+import (
+	"sync"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//LoginAttemptService represents an in-memory implementation of the finisafricae.LoginAttemptService interface.
+//The zero value is ready to use.
+type LoginAttemptService struct {
+	mu       sync.Mutex
+	attempts map[string]finisafricae.LoginAttempt
+}
+
+//LoginAttempt returns the attempts of the key
+func (s *LoginAttemptService) LoginAttempt(key string) (*finisafricae.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.attempts[key]
+	if !ok {
+		a = finisafricae.LoginAttempt{Key: key}
+	}
+	return &a, nil
+}
+
+//AddFailure counts a failure of the key while holding the lock, so concurrent failures are all counted
+func (s *LoginAttemptService) AddFailure(key string, now, forgetBefore time.Time) (*finisafricae.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attempts == nil {
+		s.attempts = make(map[string]finisafricae.LoginAttempt)
+	}
+	a := s.attempts[key]
+	a.Key = key
+	if a.LastFailure.Before(forgetBefore) {
+		a.Failures = 0
+	}
+	a.Failures++
+	a.LastFailure = now
+	s.attempts[key] = a
+	return &a, nil
+}
+
+//LockLoginAttempt locks the key out until the given time, unless it is locked out for longer already
+func (s *LoginAttemptService) LockLoginAttempt(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attempts == nil {
+		s.attempts = make(map[string]finisafricae.LoginAttempt)
+	}
+	a := s.attempts[key]
+	a.Key = key
+	if until.After(a.LockedUntil) {
+		a.LockedUntil = until
+	}
+	s.attempts[key] = a
+	return nil
+}
+
+//DeleteLoginAttempt deletes the attempts of the key
+func (s *LoginAttemptService) DeleteLoginAttempt(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
+
+//DeleteExpired deletes the attempts last failed before the given time
+func (s *LoginAttemptService) DeleteExpired(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for key, a := range s.attempts {
+		if a.LastFailure.Before(before) && a.LockedUntil.Before(before) {
+			delete(s.attempts, key)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,120 @@
+//Package lockout slows down password guessing. After a number of failed logins for an account or from a client address,
+//further logins are refused for a time that doubles with every failure.
+package lockout
+
+import (
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//Policy is how failed logins of one kind of key are punished. Free failures are allowed before the first lockout, which lasts
+//Backoff and doubles with every failure after it, up to MaxBackoff. Failures are forgotten after Forget without any.
+type Policy struct {
+	Free       int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Forget     time.Duration
+}
+
+//lockout returns how long to lock a key out after its nth failure
+func (p Policy) lockout(n int) time.Duration {
+	if n <= p.Free {
+		return 0
+	}
+	d := float64(p.Backoff) * math.Pow(2, float64(n-p.Free-1))
+	if d > float64(p.MaxBackoff) {
+		return p.MaxBackoff
+	}
+	return time.Duration(d)
+}
+
+//Limiter keeps track of failed logins per account, by email, and per client address. Addresses are usually allowed more
+//failures than accounts, as many users may share one.
+type Limiter struct {
+	LoginAttemptService finisafricae.LoginAttemptService
+	Account             Policy
+	Address             Policy
+	//OnLock, if set, is called when a key is locked out
+	OnLock func(a *finisafricae.LoginAttempt)
+}
+
+//accountKey returns the key of the account of email. Emails longer than any user can have are cut, at a character
+//boundary, so keys fit the database whatever is typed in the login form.
+func accountKey(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if len(email) > finisafricae.EmailMaxLength {
+		n := finisafricae.EmailMaxLength
+		for n > 0 && !utf8.RuneStart(email[n]) {
+			n--
+		}
+		email = email[:n]
+	}
+	return "account:" + email
+}
+
+func addressKey(ip string) string { return "address:" + ip }
+
+//Check returns an ErrRateLimited error if logins to the account of email or from the address ip are locked out
+func (l *Limiter) Check(email, ip string) error {
+	var until time.Time
+	for _, key := range []string{accountKey(email), addressKey(ip)} {
+		a, err := l.LoginAttemptService.LoginAttempt(key)
+		if err != nil {
+			return err
+		}
+		if a.LockedUntil.After(until) {
+			until = a.LockedUntil
+		}
+	}
+	if wait := time.Until(until); wait > 0 {
+		return finisafricae.Errorf(finisafricae.ErrRateLimited, "Too many failed logins. Try again in %v.", wait.Round(time.Second))
+	}
+	return nil
+}
+
+//Fail records a failed login to the account of email from the address ip
+func (l *Limiter) Fail(email, ip string) error {
+	if err := l.fail(accountKey(email), l.Account); err != nil {
+		return err
+	}
+	return l.fail(addressKey(ip), l.Address)
+}
+
+//fail counts a failure of the key and locks it out by the count it reached, so failures at the same time each count
+func (l *Limiter) fail(key string, p Policy) error {
+	now := time.Now().UTC()
+	a, err := l.LoginAttemptService.AddFailure(key, now, now.Add(-p.Forget))
+	if err != nil {
+		return err
+	}
+	d := p.lockout(a.Failures)
+	if d <= 0 {
+		return nil
+	}
+	a.LockedUntil = now.Add(d)
+	if err := l.LoginAttemptService.LockLoginAttempt(key, a.LockedUntil); err != nil {
+		return err
+	}
+	if l.OnLock != nil {
+		l.OnLock(a)
+	}
+	return nil
+}
+
+//Succeed forgets the failed logins to the account of email. Those from the address are kept, or an attacker could
+//reset them by logging into an account of their own between guesses.
+func (l *Limiter) Succeed(email string) error {
+	return l.LoginAttemptService.DeleteLoginAttempt(accountKey(email))
+}
+
+//Forget returns the longest time failures are remembered, after which they can be deleted
+func (l *Limiter) Forget() time.Duration {
+	if l.Account.Forget > l.Address.Forget {
+		return l.Account.Forget
+	}
+	return l.Address.Forget
+}
@@ -0,0 +1,122 @@
+package lockout
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/madskrogh/finisafricae"
+	"github.com/madskrogh/finisafricae/inmem"
+)
+
+func TestPolicyLockout(t *testing.T) {
+	p := Policy{Free: 3, Backoff: time.Second, MaxBackoff: 10 * time.Second}
+	for n, want := range map[int]time.Duration{0: 0, 3: 0, 4: time.Second, 5: 2 * time.Second, 6: 4 * time.Second, 7: 8 * time.Second, 8: 10 * time.Second, 100: 10 * time.Second} {
+		if got := p.lockout(n); got != want {
+			t.Errorf("lockout after %d failures is %v, want %v", n, got, want)
+		}
+	}
+}
+
+func newLimiter() *Limiter {
+	return &Limiter{
+		LoginAttemptService: &inmem.LoginAttemptService{},
+		Account:             Policy{Free: 3, Backoff: time.Minute, MaxBackoff: time.Hour, Forget: 24 * time.Hour},
+		Address:             Policy{Free: 10, Backoff: time.Minute, MaxBackoff: time.Hour, Forget: 24 * time.Hour},
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter()
+	var locked []*finisafricae.LoginAttempt
+	l.OnLock = func(a *finisafricae.LoginAttempt) { locked = append(locked, a) }
+	for i := 0; i < 3; i++ {
+		if err := l.Check("Reader@example.com", "10.0.0.1"); err != nil {
+			t.Fatalf("locked out after %d failures: %v", i, err)
+		}
+		if err := l.Fail("reader@example.com ", "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Check("reader@example.com", "10.0.0.2"); err != nil {
+		t.Fatalf("locked out after the free failures: %v", err)
+	}
+	l.Fail("reader@example.com", "10.0.0.1")
+	if err := l.Check("reader@example.com", "10.0.0.2"); !errors.Is(err, finisafricae.ErrRateLimited) {
+		t.Fatalf("the account isn't locked out from another address: %v", err)
+	}
+	if err := l.Check("writer@example.com", "10.0.0.2"); err != nil {
+		t.Fatalf("another account is locked out: %v", err)
+	}
+	if len(locked) != 1 || locked[0].Key != "account:reader@example.com" || time.Until(locked[0].LockedUntil).Round(time.Minute) != time.Minute {
+		t.Fatalf("OnLock got %+v", locked)
+	}
+	//Logging in, such as with a password that was right after all, resets the account but not the address
+	if err := l.Succeed("reader@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Check("reader@example.com", "10.0.0.1"); err != nil {
+		t.Fatalf("locked out after logging in: %v", err)
+	}
+	a, _ := l.LoginAttemptService.LoginAttempt("address:10.0.0.1")
+	if a.Failures != 4 {
+		t.Errorf("the address has %d failures, want 4", a.Failures)
+	}
+}
+
+func TestLimiterForget(t *testing.T) {
+	l := newLimiter()
+	s := l.LoginAttemptService
+	long := time.Now().Add(-48 * time.Hour)
+	for i := 0; i < 5; i++ {
+		s.AddFailure(accountKey("reader@example.com"), long, long.Add(-l.Account.Forget))
+	}
+	l.Fail("reader@example.com", "10.0.0.1")
+	a, _ := s.LoginAttempt(accountKey("reader@example.com"))
+	if a.Failures != 1 || !a.LockedUntil.IsZero() {
+		t.Errorf("failures long ago weren't forgotten: %+v", a)
+	}
+}
+
+func TestLimiterConcurrent(t *testing.T) {
+	l := newLimiter()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.Fail("reader@example.com", "10.0.0.1"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	a, _ := l.LoginAttemptService.LoginAttempt(accountKey("reader@example.com"))
+	if a.Failures != 50 {
+		t.Errorf("50 concurrent failures counted as %d", a.Failures)
+	}
+	//The longest lockout of the concurrent failures is kept
+	if time.Until(a.LockedUntil) < 59*time.Minute {
+		t.Errorf("locked out until %v, want an hour from now", a.LockedUntil)
+	}
+}
+
+func TestAccountKey(t *testing.T) {
+	long := strings.Repeat("a", 250) + "@example.com"
+	for email, want := range map[string]string{
+		" Reader@Example.com ":         "account:reader@example.com",
+		long:                           "account:" + long[:finisafricae.EmailMaxLength],
+		strings.Repeat("a", 254) + "é": "account:" + strings.Repeat("a", 254),
+	} {
+		got := accountKey(email)
+		if got != want {
+			t.Errorf("key of %q is %q, want %q", email, got, want)
+		}
+		if !utf8.ValidString(got) || len(got) > len("account:")+finisafricae.EmailMaxLength {
+			t.Errorf("key of %q doesn't fit the database", email)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package mail
+
+import (
+	"io"
+	"sync"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//LogMailer writes mail to W instead of sending it, so links in mail can be followed during development without a mail server
+type LogMailer struct {
+	W    io.Writer
+	From string
+
+	mu sync.Mutex
+}
+
+//Send writes m to W, followed by a separator line
+func (l *LogMailer) Send(m *finisafricae.Mail) error {
+	msg, err := message(l.From, m)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.W.Write(msg); err != nil {
+		return err
+	}
+	_, err = io.WriteString(l.W, "\r\n----\r\n")
+	return err
+}
@@ -0,0 +1,32 @@
+//Package mail implements finisafricae.Mailer, sending mail over SMTP or writing it to a file or log for development
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//message returns m as an RFC 5322 message from the given address
+func message(from string, m *finisafricae.Mail) ([]byte, error) {
+	//Line breaks in headers would let a recipient or subject add headers of their own
+	for _, h := range []string{from, m.To, m.Subject} {
+		if strings.ContainsAny(h, "\r\n") {
+			return nil, errors.New("mail: line break in header")
+		}
+	}
+	var b bytes.Buffer
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + m.To + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", m.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(m.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return b.Bytes(), nil
+}
@@ -0,0 +1,34 @@
+package mail
+
+import (
+	"net"
+	"net/smtp"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//SMTPMailer sends mail through an SMTP server at Addr (host:port). The connection is upgraded with STARTTLS when the server
+//supports it, and Username and Password, if set, are used for PLAIN authentication, which net/smtp only allows over TLS or to localhost.
+type SMTPMailer struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+//Send sends m
+func (s *SMTPMailer) Send(m *finisafricae.Mail) error {
+	msg, err := message(s.From, m)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	return smtp.SendMail(s.Addr, auth, s.From, []string{m.To}, msg)
+}
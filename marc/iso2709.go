@@ -0,0 +1,180 @@
+package marc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//Delimiters of the binary MARC 21 (ISO 2709) format
+const (
+	subfieldDelimiter = 0x1F
+	fieldTerminator   = 0x1E
+	recordTerminator  = 0x1D
+)
+
+//ErrFormat is returned when a binary record is malformed
+var ErrFormat = errors.New("marc: malformed record")
+
+//ErrEncoding is returned for binary records in MARC-8, of which only the ASCII subset is supported. UTF-8 records
+//(leader position 9 "a") are read in full.
+var ErrEncoding = errors.New("marc: only UTF-8 records are supported")
+
+//Read returns the books of every record in a stream of binary MARC 21 records. Records must be in UTF-8, or in MARC-8
+//using only its ASCII subset.
+func Read(r io.Reader) ([]*finisafricae.Book, error) {
+	bs := make([]*finisafricae.Book, 0)
+	br := bufio.NewReader(r)
+	for {
+		data, err := br.ReadBytes(recordTerminator)
+		if err == io.EOF {
+			//Trailing whitespace after the last record is tolerated, anything else is a truncated record
+			if len(bytes.TrimSpace(data)) > 0 {
+				return nil, ErrFormat
+			}
+			return bs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		rec, err := decode(bytes.TrimLeft(data, "\r\n "))
+		if err != nil {
+			return nil, err
+		}
+		bs = append(bs, bookFromRecord(rec))
+	}
+}
+
+//Write writes the books as binary MARC 21 records
+func Write(w io.Writer, bs []*finisafricae.Book) error {
+	for _, b := range bs {
+		data, err := encode(recordFromBook(b))
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//decode parses a single record, including its record terminator
+func decode(data []byte) (*record, error) {
+	if len(data) < 25 {
+		return nil, ErrFormat
+	}
+	base, err := strconv.Atoi(string(data[12:17]))
+	if err != nil || base < 25 || base > len(data) {
+		return nil, ErrFormat
+	}
+	if data[9] != 'a' && !ascii(data) {
+		return nil, ErrEncoding
+	}
+	rec := &record{Leader: string(data[:24])}
+	//The directory runs from the end of the leader to the field terminator just before the base address
+	dir := data[24 : base-1]
+	if len(dir)%12 != 0 {
+		return nil, ErrFormat
+	}
+	for i := 0; i < len(dir); i += 12 {
+		tag := string(dir[i : i+3])
+		length, err1 := strconv.Atoi(string(dir[i+3 : i+7]))
+		start, err2 := strconv.Atoi(string(dir[i+7 : i+12]))
+		if err1 != nil || err2 != nil || start < 0 || length < 0 || base+start+length > len(data) {
+			return nil, ErrFormat
+		}
+		value := bytes.TrimSuffix(data[base+start:base+start+length], []byte{fieldTerminator})
+		if tag < "010" {
+			rec.ControlFields = append(rec.ControlFields, controlField{Tag: tag, Value: string(value)})
+			continue
+		}
+		if len(value) < 2 {
+			return nil, ErrFormat
+		}
+		f := dataField{Tag: tag, Ind1: string(value[0]), Ind2: string(value[1])}
+		for _, sf := range bytes.Split(value[2:], []byte{subfieldDelimiter}) {
+			if len(sf) == 0 {
+				continue
+			}
+			f.Subfields = append(f.Subfields, subfield{Code: string(sf[0]), Value: string(sf[1:])})
+		}
+		rec.DataFields = append(rec.DataFields, f)
+	}
+	return rec, nil
+}
+
+//encode serializes a record, computing the directory, base address and record length
+func encode(rec *record) ([]byte, error) {
+	var dir, fields bytes.Buffer
+	//The directory has four digits for the length of a field and five for where it starts
+	entry := func(tag string, value []byte) error {
+		if len(value) > 9999 {
+			return fmt.Errorf("marc: field %s of %d bytes exceeds the format limit", tag, len(value))
+		}
+		if fields.Len() > 99999 {
+			return fmt.Errorf("marc: field %s starts past the format limit", tag)
+		}
+		fmt.Fprintf(&dir, "%3s%04d%05d", tag, len(value), fields.Len())
+		fields.Write(value)
+		return nil
+	}
+	for _, f := range rec.ControlFields {
+		if err := entry(f.Tag, append([]byte(f.Value), fieldTerminator)); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range rec.DataFields {
+		var v bytes.Buffer
+		v.WriteString(indicator(f.Ind1))
+		v.WriteString(indicator(f.Ind2))
+		for _, sf := range f.Subfields {
+			v.WriteByte(subfieldDelimiter)
+			v.WriteString(sf.Code)
+			v.WriteString(sf.Value)
+		}
+		v.WriteByte(fieldTerminator)
+		if err := entry(f.Tag, v.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	base := 24 + dir.Len() + 1
+	length := base + fields.Len() + 1
+	if length > 99999 {
+		return nil, fmt.Errorf("marc: record of %d bytes exceeds the format limit", length)
+	}
+	l := []byte(rec.Leader)
+	copy(l[0:5], fmt.Sprintf("%05d", length))
+	copy(l[12:17], fmt.Sprintf("%05d", base))
+
+	var out bytes.Buffer
+	out.Write(l)
+	out.Write(dir.Bytes())
+	out.WriteByte(fieldTerminator)
+	out.Write(fields.Bytes())
+	out.WriteByte(recordTerminator)
+	return out.Bytes(), nil
+}
+
+//ascii returns true if data has no bytes outside ASCII, where MARC-8 and UTF-8 agree
+func ascii(data []byte) bool {
+	for _, c := range data {
+		if c >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+//indicator returns a single character indicator, blank if none is set
+func indicator(s string) string {
+	if len(s) != 1 {
+		return " "
+	}
+	return s
+}
@@ -0,0 +1,134 @@
+//Package marc reads and writes MARC 21 bibliographic records, in both MARCXML and binary (ISO 2709) form, and maps them to and from finisafricae.Book
+package marc
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//record is a single MARC 21 record. The xml tags match the MARCXML slim schema, the binary codec fills the same struct.
+type record struct {
+	Leader        string         `xml:"leader"`
+	ControlFields []controlField `xml:"controlfield"`
+	DataFields    []dataField    `xml:"datafield"`
+}
+
+type controlField struct {
+	Tag   string `xml:"tag,attr"`
+	Value string `xml:",chardata"`
+}
+
+type dataField struct {
+	Tag       string     `xml:"tag,attr"`
+	Ind1      string     `xml:"ind1,attr"`
+	Ind2      string     `xml:"ind2,attr"`
+	Subfields []subfield `xml:"subfield"`
+}
+
+type subfield struct {
+	Code  string `xml:"code,attr"`
+	Value string `xml:",chardata"`
+}
+
+//leader is used for exported records: new, language material, monograph, UTF-8, ISBD punctuation.
+//Record length and base address (positions 0-4 and 12-16) are filled in by the binary writer.
+const leader = "00000nam a2200000 i 4500"
+
+var yearPattern = regexp.MustCompile(`[0-9]{4}`)
+
+//field returns the first data field with the given tag, or nil
+func (r *record) field(tag string) *dataField {
+	for i := range r.DataFields {
+		if r.DataFields[i].Tag == tag {
+			return &r.DataFields[i]
+		}
+	}
+	return nil
+}
+
+//subfield returns the value of the first subfield with the given code
+func (f *dataField) subfield(code string) string {
+	if f == nil {
+		return ""
+	}
+	for _, sf := range f.Subfields {
+		if sf.Code == code {
+			return sf.Value
+		}
+	}
+	return ""
+}
+
+//bookFromRecord maps title (245), author (100), year (264/260), genre (655/650), ISBN (020) and notes (500) to a book.
+//ID and UserID are left empty for the caller to fill in.
+func bookFromRecord(r *record) *finisafricae.Book {
+	b := &finisafricae.Book{}
+	t := r.field("245")
+	b.Title = trim(t.subfield("a"))
+	if st := trim(t.subfield("b")); st != "" {
+		b.Title += ": " + st
+	}
+	b.Author = trim(r.field("100").subfield("a"))
+	//Prefer the publication statement (264 with second indicator 1) and fall back to the older 260
+	for _, f := range r.DataFields {
+		if f.Tag == "264" && f.Ind2 == "1" {
+			b.Year = yearPattern.FindString(f.subfield("c"))
+			break
+		}
+	}
+	if b.Year == "" {
+		b.Year = yearPattern.FindString(r.field("264").subfield("c"))
+	}
+	if b.Year == "" {
+		b.Year = yearPattern.FindString(r.field("260").subfield("c"))
+	}
+	b.Genre = trim(r.field("655").subfield("a"))
+	if b.Genre == "" {
+		b.Genre = trim(r.field("650").subfield("a"))
+	}
+	//020 $a may carry qualifiers, e.g. "9780151446476 (hardcover)"
+	if f := strings.Fields(r.field("020").subfield("a")); len(f) > 0 {
+		b.ISBN = f[0]
+	}
+	b.Notes = strings.TrimSpace(r.field("500").subfield("a"))
+	return b
+}
+
+//recordFromBook maps a book to a minimal MARC 21 record. Empty book fields are left out.
+func recordFromBook(b *finisafricae.Book) *record {
+	r := &record{Leader: leader}
+	if b.ID != "" {
+		r.ControlFields = append(r.ControlFields, controlField{Tag: "001", Value: b.ID})
+	}
+	if b.ISBN != "" {
+		r.DataFields = append(r.DataFields, newField("020", " ", " ", "a", b.ISBN))
+	}
+	//The first indicator of 245 tells whether the title is an added entry, i.e. whether there is a main entry in 100
+	ind1 := "0"
+	if b.Author != "" {
+		r.DataFields = append(r.DataFields, newField("100", "1", " ", "a", b.Author))
+		ind1 = "1"
+	}
+	r.DataFields = append(r.DataFields, newField("245", ind1, "0", "a", b.Title))
+	if b.Year != "" {
+		r.DataFields = append(r.DataFields, newField("264", " ", "1", "c", b.Year))
+	}
+	if b.Notes != "" {
+		r.DataFields = append(r.DataFields, newField("500", " ", " ", "a", b.Notes))
+	}
+	if b.Genre != "" {
+		r.DataFields = append(r.DataFields, newField("655", " ", "4", "a", b.Genre))
+	}
+	return r
+}
+
+func newField(tag, ind1, ind2, code, value string) dataField {
+	return dataField{Tag: tag, Ind1: ind1, Ind2: ind2, Subfields: []subfield{{Code: code, Value: value}}}
+}
+
+//trim removes whitespace and the trailing ISBD punctuation catalogers put at the end of subfields
+func trim(s string) string {
+	return strings.TrimSpace(strings.TrimRight(strings.TrimSpace(s), " /:;,."))
+}
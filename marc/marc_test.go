@@ -0,0 +1,102 @@
+package marc
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+var testBooks = []*finisafricae.Book{
+	{ID: "1", Title: "The Name of the Rose", Author: "Eco, Umberto", Year: "1980", Genre: "Mystery", Notes: "Signed", ISBN: "9780151446476"},
+	{ID: "2", Title: "Il pendolo di Foucault", Author: "Eco, Umberto", Year: "1988"},
+	{Title: "Über Bücher"},
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		write func(*bytes.Buffer, []*finisafricae.Book) error
+		read  func(*bytes.Buffer) ([]*finisafricae.Book, error)
+	}{
+		{"binary", func(b *bytes.Buffer, bs []*finisafricae.Book) error { return Write(b, bs) }, func(b *bytes.Buffer) ([]*finisafricae.Book, error) { return Read(b) }},
+		{"xml", func(b *bytes.Buffer, bs []*finisafricae.Book) error { return WriteXML(b, bs) }, func(b *bytes.Buffer) ([]*finisafricae.Book, error) { return ReadXML(b) }},
+	} {
+		var buf bytes.Buffer
+		if err := c.write(&buf, testBooks); err != nil {
+			t.Fatalf("%s: writing: %v", c.name, err)
+		}
+		bs, err := c.read(&buf)
+		if err != nil {
+			t.Fatalf("%s: reading: %v", c.name, err)
+		}
+		if len(bs) != len(testBooks) {
+			t.Fatalf("%s: read %d books, want %d", c.name, len(bs), len(testBooks))
+		}
+		for i, b := range bs {
+			//IDs are not imported
+			want := *testBooks[i]
+			want.ID = ""
+			if !reflect.DeepEqual(*b, want) {
+				t.Errorf("%s: book %d is %+v, want %+v", c.name, i, *b, want)
+			}
+		}
+	}
+}
+
+//testRecord returns the binary record of a book with one field, for corrupting
+func testRecord(t *testing.T) []byte {
+	data, err := encode(recordFromBook(&finisafricae.Book{Title: "Rose"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestReadMalformed(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		corrupt func([]byte) []byte
+		err     error
+	}{
+		{"truncated", func(d []byte) []byte { return d[:len(d)-5] }, ErrFormat},
+		{"short", func(d []byte) []byte { return []byte("00010nam\x1d") }, ErrFormat},
+		{"base address", func(d []byte) []byte { copy(d[12:17], "99999"); return d }, ErrFormat},
+		{"base address not a number", func(d []byte) []byte { copy(d[12:17], "abcde"); return d }, ErrFormat},
+		{"negative length", func(d []byte) []byte { copy(d[27:31], "-001"); return d }, ErrFormat},
+		{"negative start", func(d []byte) []byte { copy(d[31:36], "-0001"); return d }, ErrFormat},
+		{"length past the end", func(d []byte) []byte { copy(d[27:31], "9999"); return d }, ErrFormat},
+		{"directory", func(d []byte) []byte { copy(d[12:17], "00030"); return d }, ErrFormat},
+		{"MARC-8", func(d []byte) []byte {
+			d[9] = ' '
+			return bytes.Replace(d, []byte("Rose"), []byte("Ros\xe2"), 1)
+		}, ErrEncoding},
+	} {
+		_, err := Read(bytes.NewReader(c.corrupt(testRecord(t))))
+		if !errors.Is(err, c.err) {
+			t.Errorf("%s: got error %v, want %v", c.name, err, c.err)
+		}
+	}
+}
+
+func TestReadASCIIMARC8(t *testing.T) {
+	d := testRecord(t)
+	d[9] = ' '
+	bs, err := Read(bytes.NewReader(d))
+	if err != nil || len(bs) != 1 || bs[0].Title != "Rose" {
+		t.Errorf("got %v, %v", bs, err)
+	}
+}
+
+func TestWriteTooLong(t *testing.T) {
+	//A 500 field holds two indicators, the subfield delimiter and code and the field terminator besides the notes
+	for n, ok := range map[int]bool{9994: true, 9995: false, 20000: false} {
+		err := Write(&bytes.Buffer{}, []*finisafricae.Book{{Title: "Rose", Notes: strings.Repeat("n", n)}})
+		if (err == nil) != ok {
+			t.Errorf("writing notes of %d bytes: got error %v", n, err)
+		}
+	}
+}
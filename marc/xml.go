@@ -0,0 +1,58 @@
+package marc
+
+import (
+	"encoding/xml"
+	"io"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//Namespace is the MARCXML slim schema namespace
+const Namespace = "http://www.loc.gov/MARC21/slim"
+
+type collection struct {
+	XMLName xml.Name  `xml:"collection"`
+	Xmlns   string    `xml:"xmlns,attr"`
+	Records []*record `xml:"record"`
+}
+
+//ReadXML returns the books of every record in a MARCXML document. Both a <collection> and a single <record> root are accepted.
+func ReadXML(r io.Reader) ([]*finisafricae.Book, error) {
+	bs := make([]*finisafricae.Book, 0)
+	d := xml.NewDecoder(r)
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return bs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		//Records are picked out wherever they are, so namespaced and un-namespaced documents are read alike
+		if se, ok := t.(xml.StartElement); ok && se.Name.Local == "record" {
+			var rec record
+			if err := d.DecodeElement(&rec, &se); err != nil {
+				return nil, err
+			}
+			bs = append(bs, bookFromRecord(&rec))
+		}
+	}
+}
+
+//WriteXML writes the books as a MARCXML collection
+func WriteXML(w io.Writer, bs []*finisafricae.Book) error {
+	c := collection{Xmlns: Namespace}
+	for _, b := range bs {
+		c.Records = append(c.Records, recordFromBook(b))
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+	if err := e.Encode(c); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,78 @@
+package mysql
+
+import (
+	"database/sql"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//APITokenService represents a MySQL implementation of the finisafricae.APITokenService interface.
+type APITokenService struct {
+	DB *sql.DB
+}
+
+//apiTokenColumns are the columns scanned by scanAPIToken, in order
+const apiTokenColumns = `id, userid, name, scope, hash, created, lastused`
+
+func scanAPIToken(row scanner) (*finisafricae.APIToken, error) {
+	var t finisafricae.APIToken
+	var lastUsed sql.NullTime
+	if err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Scope, &t.Hash, &t.Created, &lastUsed); err != nil {
+		return nil, err
+	}
+	t.LastUsed = lastUsed.Time
+	return &t, nil
+}
+
+//APIToken returns the APIToken with the given hash.
+func (s *APITokenService) APIToken(hash string) (*finisafricae.APIToken, error) {
+	t, err := scanAPIToken(s.DB.QueryRow(`SELECT `+apiTokenColumns+` FROM api_token WHERE hash = ?`, hash))
+	if err == sql.ErrNoRows {
+		return nil, finisafricae.Errorf(finisafricae.ErrNotFound, "The API token was not found.")
+	}
+	return t, err
+}
+
+//APITokensForUser returns the APITokens of the user with the given id, newest first
+func (s *APITokenService) APITokensForUser(userID string) ([]*finisafricae.APIToken, error) {
+	ts := make([]*finisafricae.APIToken, 0)
+	rows, err := s.DB.Query(`SELECT `+apiTokenColumns+` FROM api_token WHERE userid = ? ORDER BY created DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		ts = append(ts, t)
+	}
+	return ts, rows.Err()
+}
+
+//CreateAPIToken inserts new APIToken into table
+func (s *APITokenService) CreateAPIToken(t *finisafricae.APIToken) error {
+	sqlStatement := `INSERT INTO api_token (` + apiTokenColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	_, err := s.DB.Exec(sqlStatement, t.ID, t.UserID, t.Name, t.Scope, t.Hash, t.Created.UTC(), nullTime(t.LastUsed))
+	return err
+}
+
+//UpdateAPIToken updates the name, scope and last use of an APIToken in the table
+func (s *APITokenService) UpdateAPIToken(t *finisafricae.APIToken) error {
+	sqlStatement := `UPDATE api_token SET name=?, scope=?, lastused=? WHERE id = ?`
+	_, err := s.DB.Exec(sqlStatement, t.Name, t.Scope, nullTime(t.LastUsed), t.ID)
+	return err
+}
+
+//DeleteAPIToken deletes record with matching id from table
+func (s *APITokenService) DeleteAPIToken(id string) error {
+	_, err := s.DB.Exec(`DELETE FROM api_token WHERE id=?`, id)
+	return err
+}
+
+//DeleteAPITokensForUser deletes all records of the user with the given id
+func (s *APITokenService) DeleteAPITokensForUser(userID string) error {
+	_, err := s.DB.Exec(`DELETE FROM api_token WHERE userid=?`, userID)
+	return err
+}
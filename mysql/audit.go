@@ -0,0 +1,74 @@
+package mysql
+
+import (
+	"database/sql"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//AuditService represents a MySQL implementation of the finisafricae.AuditService interface.
+type AuditService struct {
+	DB *sql.DB
+}
+
+//auditColumns are the columns scanned by scanAuditEvent, in order
+const auditColumns = `id, time, type, userid, actorid, ip, detail`
+
+func scanAuditEvent(row scanner) (*finisafricae.AuditEvent, error) {
+	var e finisafricae.AuditEvent
+	if err := row.Scan(&e.ID, &e.Time, &e.Type, &e.UserID, &e.ActorID, &e.IP, &e.Detail); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+//RecordAuditEvent inserts e into table and sets its ID
+func (s *AuditService) RecordAuditEvent(e *finisafricae.AuditEvent) error {
+	//Details are cut to fit the column rather than losing the event
+	detail := e.Detail
+	if r := []rune(detail); len(r) > 255 {
+		detail = string(r[:255])
+	}
+	sqlStatement := `INSERT INTO audit_event (time, type, userid, actorid, ip, detail) VALUES (?, ?, ?, ?, ?, ?)`
+	res, err := s.DB.Exec(sqlStatement, e.Time.UTC(), e.Type, e.UserID, e.ActorID, e.IP, detail)
+	if err != nil {
+		return err
+	}
+	e.ID, err = res.LastInsertId()
+	return err
+}
+
+//AuditEvents returns the events selected by f, newest first
+func (s *AuditService) AuditEvents(f finisafricae.AuditFilter) ([]*finisafricae.AuditEvent, error) {
+	q, args := `SELECT `+auditColumns+` FROM audit_event WHERE 1=1`, []interface{}{}
+	if f.UserID != "" {
+		q, args = q+` AND userid = ?`, append(args, f.UserID)
+	}
+	if f.Type != "" {
+		q, args = q+` AND type = ?`, append(args, f.Type)
+	}
+	if !f.From.IsZero() {
+		q, args = q+` AND time >= ?`, append(args, f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		q, args = q+` AND time < ?`, append(args, f.To.UTC())
+	}
+	q += ` ORDER BY time DESC, id DESC`
+	if f.Limit > 0 {
+		q, args = q+` LIMIT ?`, append(args, f.Limit)
+	}
+	es := make([]*finisafricae.AuditEvent, 0)
+	rows, err := s.DB.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		es = append(es, e)
+	}
+	return es, rows.Err()
+}
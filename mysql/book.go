@@ -4,7 +4,6 @@ import (
 	"database/sql"
 
 	"github.com/madskrogh/finisafricae"
-	"github.com/madskrogh/finisafricae/util"
 )
 
 //BookService represents a MySQL implementation of the finisafricae.BookService interface.
@@ -16,7 +15,9 @@ type BookService struct {
 func (s *BookService) Book(id string) (*finisafricae.Book, error) {
 	var b finisafricae.Book
 	row := s.DB.QueryRow(`SELECT * FROM book WHERE id = ?`, id)
-	if err := row.Scan(&b.ID, &b.UserID, &b.Title, &b.Author, &b.Year, &b.Genre, &b.Notes); err != nil {
+	if err := row.Scan(&b.ID, &b.UserID, &b.Title, &b.Author, &b.Year, &b.Genre, &b.Notes, &b.ISBN); err == sql.ErrNoRows {
+		return nil, finisafricae.Errorf(finisafricae.ErrNotFound, "The book was not found.")
+	} else if err != nil {
 		return nil, err
 	}
 	return &b, nil
@@ -26,29 +27,44 @@ func (s *BookService) Book(id string) (*finisafricae.Book, error) {
 func (s *BookService) Books(userID string) ([]*finisafricae.Book, error) {
 	bs := make([]*finisafricae.Book, 0)
 	rows, err := s.DB.Query(`SELECT * FROM book WHERE userid = ?`, userID)
-	util.HandleError(err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 	for rows.Next() {
 		b := finisafricae.Book{}
-		err := rows.Scan(&b.ID, &b.UserID, &b.Title, &b.Author, &b.Year, &b.Genre, &b.Notes)
+		err := rows.Scan(&b.ID, &b.UserID, &b.Title, &b.Author, &b.Year, &b.Genre, &b.Notes, &b.ISBN)
 		if err != nil {
 			return nil, err
 		}
 		bs = append(bs, &b)
 	}
-	return bs, nil
+	return bs, rows.Err()
 }
 
-//CreateBook inserts new book into table
+//CreateBook inserts new book into table. Titles are unique within the library of a user.
 func (s *BookService) CreateBook(b *finisafricae.Book) error {
-	sqlStatement := `INSERT INTO book (id,userid,title,author,year,genre,notes) VALUES (?, ?, ?, ?, ?, ?, ?)`
-	_, err := s.DB.Exec(sqlStatement, &b.ID, &b.UserID, &b.Title, &b.Author, &b.Year, &b.Genre, &b.Notes)
+	if err := b.Validate(); err != nil {
+		return err
+	}
+	if err := s.checkTitle(b); err != nil {
+		return err
+	}
+	sqlStatement := `INSERT INTO book (id,userid,title,author,year,genre,notes,isbn) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := s.DB.Exec(sqlStatement, &b.ID, &b.UserID, &b.Title, &b.Author, &b.Year, &b.Genre, &b.Notes, &b.ISBN)
 	return err
 }
 
 //UpdateBook updates a book in the table
 func (s *BookService) UpdateBook(b *finisafricae.Book) error {
-	sqlStatement := `UPDATE book SET userid=?, title=?, author=?, year=?, genre=?, notes=? WHERE id=?`
-	_, err := s.DB.Exec(sqlStatement, &b.ID, &b.UserID, &b.Title, &b.Author, &b.Year, &b.Genre, &b.Notes, &b.ID)
+	if err := b.Validate(); err != nil {
+		return err
+	}
+	if err := s.checkTitle(b); err != nil {
+		return err
+	}
+	sqlStatement := `UPDATE book SET userid=?, title=?, author=?, year=?, genre=?, notes=?, isbn=? WHERE id=?`
+	_, err := s.DB.Exec(sqlStatement, &b.UserID, &b.Title, &b.Author, &b.Year, &b.Genre, &b.Notes, &b.ISBN, &b.ID)
 	return err
 }
 
@@ -58,3 +74,16 @@ func (s *BookService) DeleteBook(id string) error {
 	_, err := s.DB.Exec(sqlStatement, id)
 	return err
 }
+
+//checkTitle returns an ErrConflict error if another book of the same user has the title of b
+func (s *BookService) checkTitle(b *finisafricae.Book) error {
+	var n int
+	row := s.DB.QueryRow(`SELECT COUNT(*) FROM book WHERE userid = ? AND title = ? AND id <> ?`, b.UserID, b.Title, b.ID)
+	if err := row.Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return finisafricae.Errorf(finisafricae.ErrConflict, "A book with this title already exists.")
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package mysql
+
+import (
+	"database/sql"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//IdentityService represents a MySQL implementation of the finisafricae.IdentityService interface.
+type IdentityService struct {
+	DB *sql.DB
+}
+
+//identityColumns are the columns scanned by scanIdentity, in order
+const identityColumns = `issuer, subject, userid, email, created`
+
+func scanIdentity(row scanner) (*finisafricae.Identity, error) {
+	var i finisafricae.Identity
+	if err := row.Scan(&i.Issuer, &i.Subject, &i.UserID, &i.Email, &i.Created); err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+//Identity returns the Identity with the given subject at issuer.
+func (s *IdentityService) Identity(issuer, subject string) (*finisafricae.Identity, error) {
+	i, err := scanIdentity(s.DB.QueryRow(`SELECT `+identityColumns+` FROM identity WHERE issuer = ? AND subject = ?`, issuer, subject))
+	if err == sql.ErrNoRows {
+		return nil, finisafricae.Errorf(finisafricae.ErrNotFound, "The account is not linked to any user.")
+	}
+	return i, err
+}
+
+//IdentitiesForUser returns the Identities linked to the user with the given id
+func (s *IdentityService) IdentitiesForUser(userID string) ([]*finisafricae.Identity, error) {
+	is := make([]*finisafricae.Identity, 0)
+	rows, err := s.DB.Query(`SELECT `+identityColumns+` FROM identity WHERE userid = ? ORDER BY created`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		i, err := scanIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		is = append(is, i)
+	}
+	return is, rows.Err()
+}
+
+//CreateIdentity inserts new Identity into table. An account can only be linked to one user.
+func (s *IdentityService) CreateIdentity(i *finisafricae.Identity) error {
+	var n int
+	row := s.DB.QueryRow(`SELECT COUNT(*) FROM identity WHERE issuer = ? AND subject = ?`, i.Issuer, i.Subject)
+	if err := row.Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return finisafricae.Errorf(finisafricae.ErrConflict, "The account is already linked to a user.")
+	}
+	sqlStatement := `INSERT INTO identity (` + identityColumns + `) VALUES (?, ?, ?, ?, ?)`
+	_, err := s.DB.Exec(sqlStatement, i.Issuer, i.Subject, i.UserID, i.Email, i.Created.UTC())
+	return err
+}
+
+//DeleteIdentity deletes record with matching issuer and subject from table
+func (s *IdentityService) DeleteIdentity(issuer, subject string) error {
+	_, err := s.DB.Exec(`DELETE FROM identity WHERE issuer=? AND subject=?`, issuer, subject)
+	return err
+}
+
+//DeleteIdentitiesForUser deletes all records of the user with the given id
+func (s *IdentityService) DeleteIdentitiesForUser(userID string) error {
+	_, err := s.DB.Exec(`DELETE FROM identity WHERE userid=?`, userID)
+	return err
+}
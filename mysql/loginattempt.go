@@ -0,0 +1,77 @@
+package mysql
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//LoginAttemptService represents a MySQL implementation of the finisafricae.LoginAttemptService interface.
+type LoginAttemptService struct {
+	DB *sql.DB
+}
+
+//LoginAttempt returns the attempts of the key, with no failures if there is no record
+func (s *LoginAttemptService) LoginAttempt(key string) (*finisafricae.LoginAttempt, error) {
+	a := finisafricae.LoginAttempt{Key: key}
+	var last, until sql.NullTime
+	row := s.DB.QueryRow(`SELECT failures, lastfailure, lockeduntil FROM login_attempt WHERE name = ?`, key)
+	if err := row.Scan(&a.Failures, &last, &until); err == sql.ErrNoRows {
+		return &a, nil
+	} else if err != nil {
+		return nil, err
+	}
+	a.LastFailure, a.LockedUntil = last.Time, until.Time
+	return &a, nil
+}
+
+//AddFailure increments the failures of the key in the database, which serializes concurrent failures, and reads the
+//record back in the same transaction, while it is still locked
+func (s *LoginAttemptService) AddFailure(key string, now, forgetBefore time.Time) (*finisafricae.LoginAttempt, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	//Assignments are made left to right, so failures is counted by the lastfailure before this one
+	sqlStatement := `INSERT INTO login_attempt (name,failures,lastfailure,lockeduntil) VALUES (?, 1, ?, NULL)
+		ON DUPLICATE KEY UPDATE failures=IF(lastfailure IS NULL OR lastfailure < ?, 1, failures + 1), lastfailure=VALUES(lastfailure)`
+	if _, err := tx.Exec(sqlStatement, key, now.UTC(), forgetBefore.UTC()); err != nil {
+		return nil, err
+	}
+	a := finisafricae.LoginAttempt{Key: key}
+	var last, until sql.NullTime
+	row := tx.QueryRow(`SELECT failures, lastfailure, lockeduntil FROM login_attempt WHERE name = ?`, key)
+	if err := row.Scan(&a.Failures, &last, &until); err != nil {
+		return nil, err
+	}
+	a.LastFailure, a.LockedUntil = last.Time, until.Time
+	return &a, tx.Commit()
+}
+
+//LockLoginAttempt sets the lockeduntil of the key to until, unless it is later already
+func (s *LoginAttemptService) LockLoginAttempt(key string, until time.Time) error {
+	_, err := s.DB.Exec(`UPDATE login_attempt SET lockeduntil=? WHERE name=? AND (lockeduntil IS NULL OR lockeduntil < ?)`, until.UTC(), key, until.UTC())
+	return err
+}
+
+//DeleteLoginAttempt deletes record with matching key from table
+func (s *LoginAttemptService) DeleteLoginAttempt(key string) error {
+	_, err := s.DB.Exec(`DELETE FROM login_attempt WHERE name=?`, key)
+	return err
+}
+
+//DeleteExpired deletes the records last failed before the given time whose lockout is over
+func (s *LoginAttemptService) DeleteExpired(before time.Time) (int64, error) {
+	res, err := s.DB.Exec(`DELETE FROM login_attempt WHERE lastfailure < ? AND (lockeduntil IS NULL OR lockeduntil < ?)`, before.UTC(), before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+//nullTime returns t in UTC, or NULL for the zero time, which MySQL may not store
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
@@ -2,18 +2,211 @@ package mysql
 
 import (
 	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/madskrogh/finisafricae/util"
+	"github.com/madskrogh/finisafricae"
+
+	driver "github.com/go-sql-driver/mysql"
 )
 
-//InitDB creates and/or remakes mysql necesarry tables for the given database db
-func InitDB(db *sql.DB) {
-	_, err := db.Exec("CREATE TABLE IF NOT EXISTS user(id varchar(64), uname varchar(32), email varchar(32), password varchar(64));")
-	util.HandleError(err)
-	_, err = db.Exec("DROP TABLE session;")
-	util.HandleError(err)
-	_, err = db.Exec("CREATE TABLE IF NOT EXISTS session(id varchar(64), userid varchar(64), time varchar(64));")
-	util.HandleError(err)
-	_, err = db.Exec("CREATE TABLE IF NOT EXISTS book(id varchar(64), userid varchar(64), title varchar(32), author varchar(32), year varchar(32), genre varchar(32), notes varchar(32));")
-	util.HandleError(err)
+//Open opens the MySQL database of the data source name dsn. Times are stored in UTC and parsed into time.Time
+//whatever the dsn says, as the services depend on it.
+func Open(dsn string) (*sql.DB, error) {
+	cfg, err := driver.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ParseTime = true
+	cfg.Loc = time.UTC
+	return sql.Open("mysql", cfg.FormatDSN())
+}
+
+//InitDB creates the necessary mysql tables for the given database db and adds columns missing from tables created by earlier versions.
+//It is safe to run against an up to date database.
+func InitDB(db *sql.DB) error {
+	//Sessions used to have a single time column. Sessions are short lived, so the old table is dropped rather than converted.
+	if ok, err := hasColumn(db, "session", "time"); err != nil {
+		return err
+	} else if ok {
+		if _, err := db.Exec("DROP TABLE session;"); err != nil {
+			return err
+		}
+	}
+	statements := []string{
+		"CREATE TABLE IF NOT EXISTS user(id varchar(64), uname varchar(32), email varchar(255), password varchar(64), verified tinyint(1) NOT NULL DEFAULT 0, totp_secret varchar(64) NOT NULL DEFAULT '', totp_step bigint NOT NULL DEFAULT 0, delete_at datetime NULL, role varchar(16) NOT NULL DEFAULT 'member', disabled tinyint(1) NOT NULL DEFAULT 0, uname_key varchar(32) NULL);",
+		"ALTER TABLE user MODIFY email varchar(255);",
+		"CREATE TABLE IF NOT EXISTS session(id varchar(64), userid varchar(64), created datetime, lastseen datetime, ip varchar(64), useragent varchar(255), impersonator varchar(64) NOT NULL DEFAULT '');",
+		"CREATE TABLE IF NOT EXISTS persistent_token(selector varchar(64), userid varchar(64), hash varchar(64), expires datetime);",
+		"CREATE TABLE IF NOT EXISTS onetime_token(hash varchar(64), userid varchar(64), purpose varchar(32), expires datetime, data varchar(255));",
+		"CREATE TABLE IF NOT EXISTS login_attempt(name varchar(320) PRIMARY KEY, failures int, lastfailure datetime, lockeduntil datetime);",
+		"CREATE TABLE IF NOT EXISTS api_token(id varchar(64), userid varchar(64), name varchar(64), scope varchar(16), hash varchar(64), created datetime, lastused datetime NULL);",
+		"CREATE TABLE IF NOT EXISTS identity(issuer varchar(255), subject varchar(255), userid varchar(64), email varchar(255), created datetime, PRIMARY KEY(issuer, subject));",
+		"CREATE TABLE IF NOT EXISTS username_history(userid varchar(64), uname varchar(32), changed datetime, uname_key varchar(32) NULL, INDEX(userid), INDEX(uname_key));",
+		"CREATE TABLE IF NOT EXISTS audit_event(id bigint AUTO_INCREMENT PRIMARY KEY, time datetime(6), type varchar(32), userid varchar(64), actorid varchar(64), ip varchar(64), detail varchar(255), INDEX(userid, time), INDEX(time));",
+		"CREATE TABLE IF NOT EXISTS public_library(userid varchar(64) PRIMARY KEY, hash varchar(64) NOT NULL DEFAULT '', fields varchar(255), on_profile tinyint(1), created datetime, INDEX(hash));",
+		"CREATE TABLE IF NOT EXISTS book(id varchar(64), userid varchar(64), title varchar(255), author varchar(255), year varchar(32), genre varchar(255), notes text, isbn varchar(32));",
+		//Titles, authors, genres and notes used to be at most 32 characters, too short for imported books
+		"ALTER TABLE book MODIFY title varchar(255), MODIFY author varchar(255), MODIFY genre varchar(255), MODIFY notes text;",
+		//Keys are "account:" followed by an email of up to 255 bytes, too long for the old column
+		"ALTER TABLE login_attempt MODIFY name varchar(320);",
+	}
+	for _, st := range statements {
+		if _, err := db.Exec(st); err != nil {
+			return err
+		}
+	}
+	columns := []struct{ table, column, definition string }{
+		{"book", "isbn", "varchar(32)"},
+		{"session", "ip", "varchar(64) NOT NULL DEFAULT ''"},
+		{"session", "useragent", "varchar(255) NOT NULL DEFAULT ''"},
+		//Users from before email verification keep their access
+		{"user", "verified", "tinyint(1) NOT NULL DEFAULT 1"},
+		{"onetime_token", "data", "varchar(255) NOT NULL DEFAULT ''"},
+		{"user", "totp_secret", "varchar(64) NOT NULL DEFAULT ''"},
+		{"user", "totp_step", "bigint NOT NULL DEFAULT 0"},
+		{"user", "delete_at", "datetime NULL"},
+		{"user", "role", "varchar(16) NOT NULL DEFAULT 'member'"},
+		{"user", "disabled", "tinyint(1) NOT NULL DEFAULT 0"},
+		{"session", "impersonator", "varchar(64) NOT NULL DEFAULT ''"},
+		{"user", "uname_key", "varchar(32) NULL"},
+		{"username_history", "uname_key", "varchar(32) NULL"},
+	}
+	for _, c := range columns {
+		if err := addColumn(db, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+	return uniqueUsers(db)
+}
+
+//errDuplicateKey is the number of the MySQL error for a value a unique index already has
+const errDuplicateKey = 1062
+
+//uniqueUsers gives the user table unique indexes on the email and on uname_key, the lowercased username. Rows from before
+//uname_key get it filled in. Users whose username only differs in case from that of another user, as was allowed then,
+//are given the username with a number added first. Users sharing an email can't be told apart, so the indexes are only
+//made once an admin has resolved them.
+func uniqueUsers(db *sql.DB) error {
+	if ok, err := hasIndex(db, "user", "user_uname_key"); err != nil || ok {
+		return err
+	}
+	if _, err := db.Exec(`UPDATE username_history SET uname_key = LOWER(uname) WHERE uname_key IS NULL`); err != nil {
+		return err
+	}
+	if ok, err := hasIndex(db, "username_history", "uname_key"); err != nil {
+		return err
+	} else if !ok {
+		if _, err := db.Exec(`ALTER TABLE username_history ADD INDEX uname_key (uname_key)`); err != nil {
+			return err
+		}
+	}
+	var emails []string
+	rows, err := db.Query(`SELECT LOWER(email) FROM user GROUP BY LOWER(email) HAVING COUNT(*) > 1`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e string
+		if err := rows.Scan(&e); err != nil {
+			return err
+		}
+		emails = append(emails, e)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(emails) > 0 {
+		return fmt.Errorf("several users have each of the emails %s. Delete all but one of them and migrate again", strings.Join(emails, ", "))
+	}
+	if err := dedupeUsernames(db); err != nil {
+		return err
+	}
+	if ok, err := hasIndex(db, "user", "user_email"); err != nil {
+		return err
+	} else if !ok {
+		if _, err := db.Exec(`ALTER TABLE user ADD UNIQUE INDEX user_email (email)`); err != nil {
+			return err
+		}
+	}
+	_, err = db.Exec(`ALTER TABLE user ADD UNIQUE INDEX user_uname_key (uname_key)`)
+	return err
+}
+
+//dedupeUsernames fills in the uname_key of users, adding a number to usernames already taken regardless of case
+func dedupeUsernames(db *sql.DB) error {
+	type user struct{ id, uname string }
+	var us []user
+	rows, err := db.Query(`SELECT id, uname FROM user ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u user
+		if err := rows.Scan(&u.id, &u.uname); err != nil {
+			return err
+		}
+		us = append(us, u)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	taken := make(map[string]bool, len(us))
+	for _, u := range us {
+		taken[usernameKey(u.uname)] = true
+	}
+	seen := make(map[string]bool, len(us))
+	for _, u := range us {
+		uname := u.uname
+		if seen[usernameKey(uname)] {
+			for i := 2; taken[usernameKey(uname)]; i++ {
+				suffix, base := strconv.Itoa(i), u.uname
+				if len(base) > finisafricae.UsernameMaxLength-len(suffix) {
+					base = base[:finisafricae.UsernameMaxLength-len(suffix)]
+				}
+				uname = base + suffix
+			}
+			log.Printf("user %s is renamed from %s to %s, as another user has the username regardless of case", u.id, u.uname, uname)
+			taken[usernameKey(uname)] = true
+		}
+		seen[usernameKey(uname)] = true
+		if _, err := db.Exec(`UPDATE user SET uname = ?, uname_key = ? WHERE id = ?`, uname, usernameKey(uname), u.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//hasIndex returns true if table has the named index
+func hasIndex(db *sql.DB, table, index string) (bool, error) {
+	var n int
+	row := db.QueryRow(`SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?`, table, index)
+	if err := row.Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+//hasColumn returns true if table has the column
+func hasColumn(db *sql.DB, table, column string) (bool, error) {
+	var n int
+	row := db.QueryRow(`SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?`, table, column)
+	if err := row.Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+//addColumn adds column to table unless it already exists. Columns must be added at the end, as the services scan rows in column order.
+func addColumn(db *sql.DB, table, column, definition string) error {
+	if ok, err := hasColumn(db, table, column); err != nil || ok {
+		return err
+	}
+	_, err := db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition + ";")
+	return err
 }
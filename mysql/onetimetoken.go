@@ -0,0 +1,66 @@
+package mysql
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//OneTimeTokenService represents a MySQL implementation of the finisafricae.OneTimeTokenService interface.
+type OneTimeTokenService struct {
+	DB *sql.DB
+}
+
+//CreateOneTimeToken inserts new OneTimeToken into table
+func (s *OneTimeTokenService) CreateOneTimeToken(t *finisafricae.OneTimeToken) error {
+	sqlStatement := `INSERT INTO onetime_token (hash,userid,purpose,expires,data) VALUES (?, ?, ?, ?, ?)`
+	_, err := s.DB.Exec(sqlStatement, t.Hash, t.UserID, t.Purpose, t.Expires.UTC(), t.Data)
+	return err
+}
+
+//OneTimeToken returns the unexpired OneTimeToken with the given hash and purpose.
+func (s *OneTimeTokenService) OneTimeToken(hash, purpose string) (*finisafricae.OneTimeToken, error) {
+	var t finisafricae.OneTimeToken
+	row := s.DB.QueryRow(`SELECT * FROM onetime_token WHERE hash = ? AND purpose = ? AND expires > ?`, hash, purpose, time.Now().UTC())
+	if err := row.Scan(&t.Hash, &t.UserID, &t.Purpose, &t.Expires, &t.Data); err == sql.ErrNoRows {
+		return nil, finisafricae.Errorf(finisafricae.ErrNotFound, "The link is invalid or has expired.")
+	} else if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+//UseOneTimeToken deletes and returns the unexpired OneTimeToken with the given hash and purpose. The token only counts as used
+//by the caller whose delete removed the row.
+func (s *OneTimeTokenService) UseOneTimeToken(hash, purpose string) (*finisafricae.OneTimeToken, error) {
+	t, err := s.OneTimeToken(hash, purpose)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.DB.Exec(`DELETE FROM onetime_token WHERE hash = ? AND purpose = ?`, hash, purpose)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, finisafricae.Errorf(finisafricae.ErrNotFound, "The link is invalid or has expired.")
+	}
+	return t, nil
+}
+
+//DeleteOneTimeTokensForUser deletes the records of the user with the given id and purpose
+func (s *OneTimeTokenService) DeleteOneTimeTokensForUser(userID, purpose string) error {
+	_, err := s.DB.Exec(`DELETE FROM onetime_token WHERE userid = ? AND purpose = ?`, userID, purpose)
+	return err
+}
+
+//DeleteExpired deletes the records that expired before the given time
+func (s *OneTimeTokenService) DeleteExpired(before time.Time) (int64, error) {
+	res, err := s.DB.Exec(`DELETE FROM onetime_token WHERE expires < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
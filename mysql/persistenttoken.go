@@ -0,0 +1,53 @@
+package mysql
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//PersistentTokenService represents a MySQL implementation of the finisafricae.PersistentTokenService interface.
+type PersistentTokenService struct {
+	DB *sql.DB
+}
+
+//PersistentToken returns the PersistentToken with the given selector.
+func (s *PersistentTokenService) PersistentToken(selector string) (*finisafricae.PersistentToken, error) {
+	var t finisafricae.PersistentToken
+	row := s.DB.QueryRow(`SELECT * FROM persistent_token WHERE selector = ?`, selector)
+	if err := row.Scan(&t.Selector, &t.UserID, &t.Hash, &t.Expires); err == sql.ErrNoRows {
+		return nil, finisafricae.Errorf(finisafricae.ErrNotFound, "The login token was not found.")
+	} else if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+//CreatePersistentToken inserts new PersistentToken into table
+func (s *PersistentTokenService) CreatePersistentToken(t *finisafricae.PersistentToken) error {
+	sqlStatement := `INSERT INTO persistent_token (selector,userid,hash,expires) VALUES (?, ?, ?, ?)`
+	_, err := s.DB.Exec(sqlStatement, t.Selector, t.UserID, t.Hash, t.Expires.UTC())
+	return err
+}
+
+//DeletePersistentToken deletes record with matching selector from table
+func (s *PersistentTokenService) DeletePersistentToken(selector string) error {
+	_, err := s.DB.Exec(`DELETE FROM persistent_token WHERE selector=?`, selector)
+	return err
+}
+
+//DeletePersistentTokensForUser deletes all records of the user with the given id
+func (s *PersistentTokenService) DeletePersistentTokensForUser(userID string) error {
+	_, err := s.DB.Exec(`DELETE FROM persistent_token WHERE userid=?`, userID)
+	return err
+}
+
+//DeleteExpired deletes the records that expired before the given time
+func (s *PersistentTokenService) DeleteExpired(before time.Time) (int64, error) {
+	res, err := s.DB.Exec(`DELETE FROM persistent_token WHERE expires < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,63 @@
+package mysql
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//PublicLibraryService represents a MySQL implementation of the finisafricae.PublicLibraryService interface.
+type PublicLibraryService struct {
+	DB *sql.DB
+}
+
+//publicLibraryColumns are the columns scanned by scanPublicLibrary, in order
+const publicLibraryColumns = `userid, hash, fields, on_profile, created`
+
+func scanPublicLibrary(row scanner) (*finisafricae.PublicLibrary, error) {
+	var l finisafricae.PublicLibrary
+	var fields string
+	if err := row.Scan(&l.UserID, &l.Hash, &fields, &l.OnProfile, &l.Created); err != nil {
+		return nil, err
+	}
+	if fields != "" {
+		l.Fields = strings.Split(fields, ",")
+	}
+	return &l, nil
+}
+
+//PublicLibrary returns the PublicLibrary of the user with the given id.
+func (s *PublicLibraryService) PublicLibrary(userID string) (*finisafricae.PublicLibrary, error) {
+	l, err := scanPublicLibrary(s.DB.QueryRow(`SELECT `+publicLibraryColumns+` FROM public_library WHERE userid = ?`, userID))
+	if err == sql.ErrNoRows {
+		return nil, finisafricae.Errorf(finisafricae.ErrNotFound, "The library is not published.")
+	}
+	return l, err
+}
+
+//PublicLibraryFromHash returns the PublicLibrary with the given hash. Libraries without a link have no hash and are never returned.
+func (s *PublicLibraryService) PublicLibraryFromHash(hash string) (*finisafricae.PublicLibrary, error) {
+	l, err := scanPublicLibrary(s.DB.QueryRow(`SELECT `+publicLibraryColumns+` FROM public_library WHERE hash = ? AND hash != ''`, hash))
+	if err == sql.ErrNoRows {
+		return nil, finisafricae.Errorf(finisafricae.ErrNotFound, "The link is not to a published library.")
+	}
+	return l, err
+}
+
+//SavePublicLibrary inserts or updates the record of l.UserID
+func (s *PublicLibraryService) SavePublicLibrary(l *finisafricae.PublicLibrary) error {
+	if err := l.Validate(); err != nil {
+		return err
+	}
+	sqlStatement := `INSERT INTO public_library (` + publicLibraryColumns + `) VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE hash=VALUES(hash), fields=VALUES(fields), on_profile=VALUES(on_profile), created=VALUES(created)`
+	_, err := s.DB.Exec(sqlStatement, l.UserID, l.Hash, strings.Join(l.Fields, ","), l.OnProfile, l.Created.UTC())
+	return err
+}
+
+//DeletePublicLibrary deletes the record of the user with the given id
+func (s *PublicLibraryService) DeletePublicLibrary(userID string) error {
+	_, err := s.DB.Exec(`DELETE FROM public_library WHERE userid=?`, userID)
+	return err
+}
@@ -2,9 +2,9 @@ package mysql
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/madskrogh/finisafricae"
-	"github.com/madskrogh/finisafricae/util"
 )
 
 //SessionService represents a MySQL implementation of the finisafricae.SessionService interface.
@@ -16,7 +16,9 @@ type SessionService struct {
 func (s *SessionService) Session(id string) (*finisafricae.Session, error) {
 	var se finisafricae.Session
 	row := s.DB.QueryRow(`SELECT * FROM session WHERE id = ?`, id)
-	if err := row.Scan(&se.ID, &se.UserID, &se.Time); err != nil {
+	if err := row.Scan(&se.ID, &se.UserID, &se.Created, &se.LastSeen, &se.IP, &se.UserAgent, &se.Impersonator); err == sql.ErrNoRows {
+		return nil, finisafricae.Errorf(finisafricae.ErrNotFound, "The session was not found.")
+	} else if err != nil {
 		return nil, err
 	}
 	return &se, nil
@@ -24,31 +26,43 @@ func (s *SessionService) Session(id string) (*finisafricae.Session, error) {
 
 //Sessions returns all Sessions in the database
 func (s *SessionService) Sessions() ([]*finisafricae.Session, error) {
+	return s.sessions(`SELECT * FROM session`)
+}
+
+//SessionsForUser returns the Sessions of the user with the given id, most recently used first
+func (s *SessionService) SessionsForUser(userID string) ([]*finisafricae.Session, error) {
+	return s.sessions(`SELECT * FROM session WHERE userid = ? ORDER BY lastseen DESC`, userID)
+}
+
+func (s *SessionService) sessions(query string, args ...interface{}) ([]*finisafricae.Session, error) {
 	ses := make([]*finisafricae.Session, 0)
-	rows, err := s.DB.Query(`SELECT * FROM session`)
-	util.HandleError(err)
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 	for rows.Next() {
 		se := finisafricae.Session{}
-		err := rows.Scan(&se.ID, &se.UserID, &se.Time) // order matters
+		err := rows.Scan(&se.ID, &se.UserID, &se.Created, &se.LastSeen, &se.IP, &se.UserAgent, &se.Impersonator) // order matters
 		if err != nil {
 			return nil, err
 		}
 		ses = append(ses, &se)
 	}
-	return ses, nil
+	return ses, rows.Err()
 }
 
 //CreateSession inserts new Session into table
 func (s *SessionService) CreateSession(se *finisafricae.Session) error {
-	sqlStatement := `INSERT INTO session (id,userid,time) VALUES (?, ?, ?)`
-	_, err := s.DB.Exec(sqlStatement, &se.ID, &se.UserID, &se.Time)
+	sqlStatement := `INSERT INTO session (id,userid,created,lastseen,ip,useragent,impersonator) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	_, err := s.DB.Exec(sqlStatement, se.ID, se.UserID, se.Created, se.LastSeen, se.IP, se.UserAgent, se.Impersonator)
 	return err
 }
 
 //UpdateSession updates a Session in the table
 func (s *SessionService) UpdateSession(se *finisafricae.Session) error {
-	sqlStatement := `UPDATE session SET id=?, userid=?, time=? WHERE id = ?`
-	_, err := s.DB.Exec(sqlStatement, se.ID, se.UserID, se.Time, se.ID)
+	sqlStatement := `UPDATE session SET id=?, userid=?, created=?, lastseen=?, ip=?, useragent=?, impersonator=? WHERE id = ?`
+	_, err := s.DB.Exec(sqlStatement, se.ID, se.UserID, se.Created, se.LastSeen, se.IP, se.UserAgent, se.Impersonator, se.ID)
 	return err
 }
 
@@ -58,3 +72,12 @@ func (s *SessionService) DeleteSession(id string) error {
 	_, err := s.DB.Exec(sqlStatement, id)
 	return err
 }
+
+//DeleteExpired deletes the records last seen before the given time
+func (s *SessionService) DeleteExpired(before time.Time) (int64, error) {
+	res, err := s.DB.Exec(`DELETE FROM session WHERE lastseen < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -2,9 +2,13 @@ package mysql
 
 import (
 	"database/sql"
+	"errors"
+	"strings"
+	"time"
 
 	"github.com/madskrogh/finisafricae"
-	"github.com/madskrogh/finisafricae/util"
+
+	driver "github.com/go-sql-driver/mysql"
 )
 
 //UserService represents a MySQL implementation of the finisafricae.UserService interaface.
@@ -12,59 +16,259 @@ type UserService struct {
 	DB *sql.DB
 }
 
-//User returns a user for a given id.
-func (s *UserService) User(id string) (*finisafricae.User, error) {
+//userColumns are the columns scanned by scanUser, in order
+const userColumns = `id, uname, email, password, verified, totp_secret, totp_step, delete_at, role, disabled`
+
+//scanner is implemented by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+//scanUser scans the userColumns of a row into a user
+func scanUser(row scanner) (*finisafricae.User, error) {
 	var u finisafricae.User
-	row := s.DB.QueryRow(`SELECT * FROM user WHERE id = ?`, id)
-	if err := row.Scan(&u.ID, &u.Uname, &u.Email, &u.Password); err != nil {
+	var deleteAt sql.NullTime
+	if err := row.Scan(&u.ID, &u.Uname, &u.Email, &u.Password, &u.Verified, &u.TOTPSecret, &u.TOTPStep, &deleteAt, &u.Role, &u.Disabled); err != nil {
 		return nil, err
 	}
+	u.DeleteAt = deleteAt.Time
 	return &u, nil
 }
 
+//User returns a user for a given id.
+func (s *UserService) User(id string) (*finisafricae.User, error) {
+	u, err := scanUser(s.DB.QueryRow(`SELECT `+userColumns+` FROM user WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, finisafricae.Errorf(finisafricae.ErrNotFound, "The user was not found.")
+	}
+	return u, err
+}
+
 //UserFromEmail returns a user for given email (used for login)
 func (s *UserService) UserFromEmail(email string) (*finisafricae.User, error) {
-	var u finisafricae.User
-	row := s.DB.QueryRow(`SELECT * FROM user WHERE email = ?`, email)
-	if err := row.Scan(&u.ID, &u.Uname, &u.Email, &u.Password); err != nil {
+	u, err := scanUser(s.DB.QueryRow(`SELECT `+userColumns+` FROM user WHERE email = ?`, email))
+	if err == sql.ErrNoRows {
+		return nil, finisafricae.Errorf(finisafricae.ErrNotFound, "No user with this email exists.")
+	}
+	return u, err
+}
+
+//UserFromUsername returns the user who has the username, or had it before, regardless of case
+func (s *UserService) UserFromUsername(uname string) (*finisafricae.User, error) {
+	u, err := scanUser(s.DB.QueryRow(`SELECT `+userColumns+` FROM user WHERE uname_key = ?`, usernameKey(uname)))
+	if err == sql.ErrNoRows {
+		//Former usernames stay with their users, so only they can be found by them
+		var id string
+		err = s.DB.QueryRow(`SELECT userid FROM username_history WHERE uname_key = ? ORDER BY changed DESC LIMIT 1`, usernameKey(uname)).Scan(&id)
+		if err == nil {
+			return s.User(id)
+		}
+	}
+	if err == sql.ErrNoRows {
+		return nil, finisafricae.Errorf(finisafricae.ErrNotFound, "No user with this username exists.")
+	}
+	return u, err
+}
+
+//UsernameHistory returns the former usernames of the user, newest first
+func (s *UserService) UsernameHistory(userID string) ([]*finisafricae.UsernameChange, error) {
+	cs := make([]*finisafricae.UsernameChange, 0)
+	rows, err := s.DB.Query(`SELECT userid, uname, changed FROM username_history WHERE userid = ? ORDER BY changed DESC`, userID)
+	if err != nil {
 		return nil, err
 	}
-	return &u, nil
+	defer rows.Close()
+	for rows.Next() {
+		var c finisafricae.UsernameChange
+		if err := rows.Scan(&c.UserID, &c.Uname, &c.Changed); err != nil {
+			return nil, err
+		}
+		cs = append(cs, &c)
+	}
+	return cs, rows.Err()
 }
 
 //Users returns all user in the table
 func (s *UserService) Users() ([]*finisafricae.User, error) {
 	us := make([]*finisafricae.User, 0)
-	rows, err := s.DB.Query(`SELECT * FROM user`)
-	util.HandleError(err)
+	rows, err := s.DB.Query(`SELECT ` + userColumns + ` FROM user`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 	for rows.Next() {
-		u := finisafricae.User{}
-		err := rows.Scan(&u.ID, &u.Uname, &u.Email, &u.Password) // order matters
+		u, err := scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
-		us = append(us, &u)
+		us = append(us, u)
 	}
-	return us, nil
+	return us, rows.Err()
 }
 
-//CreateUser inserts new user into table
+//CreateUser inserts new user into table. Emails and usernames are unique.
 func (s *UserService) CreateUser(u *finisafricae.User) error {
-	sqlStatement := `INSERT INTO user (id, uname, email, password) VALUES (?, ?, ?, ?)`
-	_, err := s.DB.Exec(sqlStatement, &u.ID, &u.Uname, &u.Email, &u.Password)
-	return err
+	if err := u.Validate(); err != nil {
+		return err
+	}
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := checkEmail(tx, u); err != nil {
+		return err
+	}
+	if err := checkUsername(tx, u); err != nil {
+		return err
+	}
+	sqlStatement := `INSERT INTO user (` + userColumns + `, uname_key) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = tx.Exec(sqlStatement, u.ID, u.Uname, u.Email, u.Password, u.Verified, u.TOTPSecret, u.TOTPStep, nullTime(u.DeleteAt), u.Role, u.Disabled, usernameKey(u.Uname))
+	if err != nil {
+		return uniqueErr(err, u)
+	}
+	return tx.Commit()
 }
 
-//UpdateUser updates user in table
+//UpdateUser updates user in table. A changed username must be free, and the old one is added to the username history.
 func (s *UserService) UpdateUser(u *finisafricae.User) error {
-	sqlStatement := `UPDATE user SET uname=?, email=?, password=? WHERE id = ?`
-	_, err := s.DB.Exec(sqlStatement, u.Uname, u.Email, u.Password, u.ID)
-	return err
+	if err := u.Validate(); err != nil {
+		return err
+	}
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := checkEmail(tx, u); err != nil {
+		return err
+	}
+	var old string
+	if err := tx.QueryRow(`SELECT uname FROM user WHERE id = ?`, u.ID).Scan(&old); err == sql.ErrNoRows {
+		return finisafricae.Errorf(finisafricae.ErrNotFound, "The user was not found.")
+	} else if err != nil {
+		return err
+	}
+	if old != u.Uname {
+		if err := checkUsername(tx, u); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO username_history (userid, uname, changed, uname_key) VALUES (?, ?, ?, ?)`, u.ID, old, time.Now().UTC(), usernameKey(old)); err != nil {
+			return err
+		}
+	}
+	sqlStatement := `UPDATE user SET uname=?, email=?, password=?, verified=?, totp_secret=?, totp_step=?, delete_at=?, role=?, disabled=?, uname_key=? WHERE id = ?`
+	if _, err := tx.Exec(sqlStatement, u.Uname, u.Email, u.Password, u.Verified, u.TOTPSecret, u.TOTPStep, nullTime(u.DeleteAt), u.Role, u.Disabled, usernameKey(u.Uname), u.ID); err != nil {
+		return uniqueErr(err, u)
+	}
+	return tx.Commit()
 }
 
-//DeleteUser deletes record with matching id from table
+//userTables are the tables with records belonging to users, by their userid column
+var userTables = []string{"book", "session", "persistent_token", "onetime_token", "api_token", "identity", "username_history", "public_library"}
+
+//DeleteUser deletes record with matching id from table, together with the records of the user in the userTables
 func (s *UserService) DeleteUser(id string) error {
-	sqlStatement := `DELETE FROM user WHERE id=?`
-	_, err := s.DB.Exec(sqlStatement, id)
-	return err
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, t := range userTables {
+		if _, err := tx.Exec(`DELETE FROM `+t+` WHERE userid=?`, id); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM user WHERE id=?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//DeleteScheduled deletes the users whose delete_at is before the given time, as DeleteUser does
+func (s *UserService) DeleteScheduled(before time.Time) (int64, error) {
+	var ids []string
+	rows, err := s.DB.Query(`SELECT id FROM user WHERE delete_at < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, id := range ids {
+		if err := s.DeleteUser(id); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+//querier is implemented by *sql.DB and *sql.Tx
+type querier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+//checkEmail returns an ErrConflict error if another user has the email of u. The unique index on the email catches
+//users created meanwhile.
+func checkEmail(q querier, u *finisafricae.User) error {
+	var n int
+	row := q.QueryRow(`SELECT COUNT(*) FROM user WHERE email = ? AND id <> ?`, u.Email, u.ID)
+	if err := row.Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return errEmailTaken
+	}
+	return nil
+}
+
+//checkUsername returns an ErrConflict error if another user has the username of u, or had it before, regardless of case.
+//The unique index on uname_key catches users taking the username meanwhile.
+func checkUsername(q querier, u *finisafricae.User) error {
+	var n int
+	row := q.QueryRow(`SELECT (SELECT COUNT(*) FROM user WHERE uname_key = ? AND id <> ?) +
+		(SELECT COUNT(*) FROM username_history WHERE uname_key = ? AND userid <> ?)`, usernameKey(u.Uname), u.ID, usernameKey(u.Uname), u.ID)
+	if err := row.Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return usernameTaken(u.Uname)
+	}
+	return nil
+}
+
+//errEmailTaken is returned for emails of other users
+var errEmailTaken = finisafricae.Errorf(finisafricae.ErrConflict, "A user with this email already exist.")
+
+//usernameTaken returns the error for a username of another user
+func usernameTaken(uname string) error {
+	return finisafricae.Errorf(finisafricae.ErrConflict, "The username %s is taken.", uname)
+}
+
+//usernameKey returns the username as stored in uname_key, where usernames differing only in case are the same
+func usernameKey(uname string) string {
+	return strings.ToLower(uname)
+}
+
+//uniqueErr returns an ErrConflict error about the email or username of u if err is from a unique index of the user table,
+//and err otherwise
+func uniqueErr(err error, u *finisafricae.User) error {
+	var me *driver.MySQLError
+	if !errors.As(err, &me) || me.Number != errDuplicateKey {
+		return err
+	}
+	if strings.Contains(me.Message, "user_email") {
+		return errEmailTaken
+	}
+	return usernameTaken(u.Uname)
 }
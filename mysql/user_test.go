@@ -0,0 +1,36 @@
+package mysql
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/madskrogh/finisafricae"
+
+	driver "github.com/go-sql-driver/mysql"
+)
+
+func TestUniqueErr(t *testing.T) {
+	u := &finisafricae.User{Uname: "Reader"}
+	other := errors.New("connection lost")
+	for _, c := range []struct {
+		err      error
+		conflict bool
+		message  string
+	}{
+		{&driver.MySQLError{Number: errDuplicateKey, Message: "Duplicate entry 'a@example.com' for key 'user.user_email'"}, true, "A user with this email already exist."},
+		{&driver.MySQLError{Number: errDuplicateKey, Message: "Duplicate entry 'reader' for key 'user.user_uname_key'"}, true, "The username Reader is taken."},
+		{&driver.MySQLError{Number: 1406, Message: "Data too long for column 'uname'"}, false, ""},
+		{other, false, ""},
+	} {
+		err := uniqueErr(c.err, u)
+		if errors.Is(err, finisafricae.ErrConflict) != c.conflict {
+			t.Errorf("%v: got %v", c.err, err)
+		}
+		if c.conflict && finisafricae.ErrorMessage(err) != c.message {
+			t.Errorf("%v: got message %q, want %q", c.err, finisafricae.ErrorMessage(err), c.message)
+		}
+		if !c.conflict && err != c.err {
+			t.Errorf("%v: got %v, want it unchanged", c.err, err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+//Package oidc signs users in with an OpenID Connect provider, using the authorization code flow with PKCE
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+//Provider is an OpenID Connect provider the application is registered with as a client
+type Provider struct {
+	//Name is shown to users, as in "Sign in with Name"
+	Name     string
+	config   oauth2.Config
+	verifier *gooidc.IDTokenVerifier
+}
+
+//Claims are what the provider tells about the user who signed in. Issuer and Subject together identify the user.
+type Claims struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	//Username is the preferred username of the user, or their name if the provider has no usernames
+	Username string
+}
+
+//New returns the provider at the issuer URL, discovering its endpoints and keys. redirectURL is the callback the provider
+//sends users back to, and must be registered with the provider. ctx is used for fetching keys for as long as the provider is used.
+func New(ctx context.Context, name, issuer, clientID, clientSecret, redirectURL string) (*Provider, error) {
+	p, err := gooidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: %v", err)
+	}
+	return &Provider{
+		Name: name,
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint:     p.Endpoint(),
+			RedirectURL:  redirectURL,
+			Scopes:       []string{gooidc.ScopeOpenID, "email", "profile"},
+		},
+		verifier: p.Verifier(&gooidc.Config{ClientID: clientID}),
+	}, nil
+}
+
+//NewVerifier returns a new random PKCE code verifier
+func NewVerifier() string {
+	return oauth2.GenerateVerifier()
+}
+
+//AuthCodeURL returns the URL to send the user to for signing in. state and nonce are random values checked when the user
+//comes back, and verifier is from NewVerifier. All three must be kept for Exchange.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) string {
+	return p.config.AuthCodeURL(state, gooidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+}
+
+//Exchange trades the code the user came back with for an ID token, and returns its claims once the token is verified
+//to be from the provider, for this client and for nonce
+func (p *Provider) Exchange(ctx context.Context, code, nonce, verifier string) (*Claims, error) {
+	t, err := p.config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("oidc: exchanging code: %v", err)
+	}
+	raw, ok := t.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("oidc: no id_token in token response")
+	}
+	idt, err := p.verifier.Verify(ctx, raw)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: %v", err)
+	}
+	if idt.Nonce != nonce {
+		return nil, errors.New("oidc: wrong nonce in id_token")
+	}
+	var cs struct {
+		Email             string   `json:"email"`
+		EmailVerified     flexBool `json:"email_verified"`
+		PreferredUsername string   `json:"preferred_username"`
+		Name              string   `json:"name"`
+	}
+	if err := idt.Claims(&cs); err != nil {
+		return nil, fmt.Errorf("oidc: %v", err)
+	}
+	c := &Claims{Issuer: idt.Issuer, Subject: idt.Subject, Email: cs.Email, EmailVerified: bool(cs.EmailVerified), Username: cs.PreferredUsername}
+	if c.Username == "" {
+		c.Username = cs.Name
+	}
+	return c, nil
+}
+
+//flexBool is a claim that should be a JSON boolean, but that some providers send as the string "true" or "false". Any
+//other value is false, so an email whose verification can't be read is taken to be unverified rather than failing the sign in.
+type flexBool bool
+
+//UnmarshalJSON implements json.Unmarshaler
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case bool:
+		*b = flexBool(v)
+	case string:
+		*b = flexBool(strings.EqualFold(v, "true"))
+	default:
+		*b = false
+	}
+	return nil
+}
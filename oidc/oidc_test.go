@@ -0,0 +1,93 @@
+package oidc_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/madskrogh/finisafricae/oidc"
+	"github.com/madskrogh/finisafricae/oidc/oidctest"
+)
+
+const redirectURL = "http://finisafricae.test/login/oidc/callback"
+
+func newProvider(t *testing.T) (*oidctest.Issuer, *oidc.Provider) {
+	t.Helper()
+	iss, err := oidctest.NewIssuer("client", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(iss.Close)
+	p, err := oidc.New(context.Background(), "Test", iss.URL, "client", "secret", redirectURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return iss, p
+}
+
+//code signs in at iss by the authorization URL of p and returns the code the callback gets
+func code(t *testing.T, iss *oidctest.Issuer, p *oidc.Provider, state, nonce, verifier string) string {
+	t.Helper()
+	back, err := iss.Authorize(p.AuthCodeURL(state, nonce, verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.Query().Get("state") != state {
+		t.Fatalf("state %q came back as %q", state, back.Query().Get("state"))
+	}
+	return back.Query().Get("code")
+}
+
+func TestExchange(t *testing.T) {
+	iss, p := newProvider(t)
+	for _, c := range []struct {
+		user         oidctest.User
+		wantUsername string
+	}{
+		{oidctest.User{Subject: "1", Email: "reader@example.com", EmailVerified: true, PreferredUsername: "reader", Name: "A Reader"}, "reader"},
+		{oidctest.User{Subject: "2", Email: "writer@example.com", Name: "A Writer"}, "A Writer"},
+		{oidctest.User{Subject: "3", Email: "string@example.com", EmailVerified: true, EmailVerifiedString: true, PreferredUsername: "string"}, "string"},
+		{oidctest.User{Subject: "4", Email: "unverified@example.com", EmailVerifiedString: true, PreferredUsername: "unverified"}, "unverified"},
+	} {
+		iss.SignIn(c.user)
+		v := oidc.NewVerifier()
+		cs, err := p.Exchange(context.Background(), code(t, iss, p, "state", "nonce", v), "nonce", v)
+		if err != nil {
+			t.Fatalf("subject %s: %v", c.user.Subject, err)
+		}
+		if cs.Issuer != iss.URL || cs.Subject != c.user.Subject || cs.Email != c.user.Email || cs.EmailVerified != c.user.EmailVerified || cs.Username != c.wantUsername {
+			t.Errorf("subject %s: got claims %+v", c.user.Subject, cs)
+		}
+	}
+}
+
+func TestExchangeWrongVerifier(t *testing.T) {
+	iss, p := newProvider(t)
+	iss.SignIn(oidctest.User{Subject: "1"})
+	c := code(t, iss, p, "state", "nonce", oidc.NewVerifier())
+	if _, err := p.Exchange(context.Background(), c, "nonce", oidc.NewVerifier()); err == nil {
+		t.Error("exchanging a code with another PKCE verifier succeeded")
+	}
+}
+
+func TestExchangeWrongNonce(t *testing.T) {
+	iss, p := newProvider(t)
+	iss.SignIn(oidctest.User{Subject: "1"})
+	v := oidc.NewVerifier()
+	c := code(t, iss, p, "state", "nonce", v)
+	if _, err := p.Exchange(context.Background(), c, "another nonce", v); err == nil {
+		t.Error("an id_token for another nonce was accepted")
+	}
+}
+
+func TestExchangeCodeOnce(t *testing.T) {
+	iss, p := newProvider(t)
+	iss.SignIn(oidctest.User{Subject: "1"})
+	v := oidc.NewVerifier()
+	c := code(t, iss, p, "state", "nonce", v)
+	if _, err := p.Exchange(context.Background(), c, "nonce", v); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Exchange(context.Background(), c, "nonce", v); err == nil {
+		t.Error("a code was exchanged twice")
+	}
+}
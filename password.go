@@ -0,0 +1,66 @@
+package finisafricae
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+//BcryptMaxLength is the most bytes of a password bcrypt uses. Anything after them is ignored.
+const BcryptMaxLength = 72
+
+//BreachedPasswords knows passwords leaked in data breaches, which are the first ones attackers try
+type BreachedPasswords interface {
+	Breached(password string) (bool, error)
+}
+
+//PasswordPolicy is what new passwords must be like. The zero value allows any password that isn't empty.
+type PasswordPolicy struct {
+	//MinLength is the fewest characters a password may have
+	MinLength int
+	//MaxLength is the most bytes a password may have. It should not exceed BcryptMaxLength.
+	MaxLength int
+	//Breached, if set, rejects passwords leaked in data breaches
+	Breached BreachedPasswords
+}
+
+//Check returns an ErrInvalid error if password doesn't follow the policy or contains the email or username of the user u
+func (p *PasswordPolicy) Check(password string, u *User) error {
+	if password == "" {
+		return Errorf(ErrInvalid, "The password must not be empty.")
+	}
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return Errorf(ErrInvalid, "The password must be at least %d characters long.", p.MinLength)
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		return Errorf(ErrInvalid, "The password must be at most %d bytes long. Letters outside the English alphabet take up more than one byte.", p.MaxLength)
+	}
+	lp := strings.ToLower(password)
+	for _, s := range []string{u.Email, strings.Split(u.Email, "@")[0], u.Uname} {
+		s = strings.ToLower(s)
+		//Short names are only refused as the whole password, or too many passwords would contain them
+		if s != "" && (lp == s || (utf8.RuneCountInString(s) >= 4 && strings.Contains(lp, s))) {
+			return Errorf(ErrInvalid, "The password must not contain your email or username.")
+		}
+	}
+	if p.Breached != nil {
+		b, err := p.Breached.Breached(password)
+		if err != nil {
+			return err
+		}
+		if b {
+			return Errorf(ErrInvalid, "The password has been leaked in a data breach, so attackers will try it. Choose another.")
+		}
+	}
+	return nil
+}
+
+//PasswordHasher hashes passwords for storage and checks passwords against stored hashes. A hasher for a new algorithm can
+//compare hashes of older ones, and ask for them to be replaced, so stored hashes are upgraded as users login.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	//Compare returns nil if password matches hash, and an ErrUnauthorized error otherwise
+	Compare(hash, password string) error
+	//NeedsRehash returns true if hash is weaker than new hashes, such as by a lower cost or an older algorithm,
+	//and should be replaced by a new hash of the password once it is known
+	NeedsRehash(hash string) bool
+}
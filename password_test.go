@@ -0,0 +1,75 @@
+package finisafricae
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+//breachedSet is a BreachedPasswords knowing the passwords of the set
+type breachedSet map[string]bool
+
+func (s breachedSet) Breached(password string) (bool, error) {
+	return s[password], nil
+}
+
+//breachedErr is a BreachedPasswords that can't be reached
+type breachedErr struct{}
+
+func (breachedErr) Breached(password string) (bool, error) {
+	return false, errors.New("breached passwords unavailable")
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	p := &PasswordPolicy{MinLength: 8, MaxLength: BcryptMaxLength, Breached: breachedSet{"correct horse": true}}
+	u := &User{Uname: "reader", Email: "bookworm@example.com"}
+	for _, c := range []struct {
+		name     string
+		password string
+		valid    bool
+	}{
+		{"empty", "", false},
+		{"too short", "aB3$xyz", false},
+		{"shortest", "aB3$xyz!", true},
+		{"short in bytes but not in characters", "æøåæøåæø", true},
+		{"longest", strings.Repeat("x", BcryptMaxLength), true},
+		{"too long", strings.Repeat("x", BcryptMaxLength+1), false},
+		{"too long in bytes", strings.Repeat("æ", BcryptMaxLength/2+1), false},
+		{"username", "my reader pass", false},
+		{"username in other case", "my READER pass", false},
+		{"email", "bookworm@example.com", false},
+		{"local part of email", "iamabookworm!", false},
+		{"breached", "correct horse", false},
+		{"not breached", "correct horse battery", true},
+	} {
+		err := p.Check(c.password, u)
+		if c.valid && err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		} else if !c.valid && !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: got %v, want an ErrInvalid error", c.name, err)
+		}
+	}
+}
+
+func TestPasswordPolicyShortNames(t *testing.T) {
+	//Names shorter than 4 characters are only refused as the whole password
+	p := &PasswordPolicy{}
+	u := &User{Uname: "bo", Email: "al@example.com"}
+	if err := p.Check("bobobobobo", u); err != nil {
+		t.Error(err)
+	}
+	if err := p.Check("Bo", u); !errors.Is(err, ErrInvalid) {
+		t.Errorf("got %v, want an ErrInvalid error", err)
+	}
+	if err := p.Check("al", u); !errors.Is(err, ErrInvalid) {
+		t.Errorf("got %v, want an ErrInvalid error", err)
+	}
+}
+
+func TestPasswordPolicyBreachedError(t *testing.T) {
+	p := &PasswordPolicy{Breached: breachedErr{}}
+	err := p.Check("correct horse battery", &User{Uname: "reader"})
+	if err == nil || errors.Is(err, ErrInvalid) {
+		t.Errorf("got %v, want the error of the breached passwords", err)
+	}
+}
@@ -0,0 +1,89 @@
+//Package pwned checks passwords against a local copy of a breached password dataset such as Pwned Passwords. The
+//dataset is split by hash prefix, k-anonymity style, so only the small file of one prefix is read per check.
+package pwned
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//Dir is a directory of files named by the first 5 hex digits of the SHA-1 hashes of breached passwords, with or without
+//a .txt extension. Each line of a file is the remaining 35 hex digits of a hash, optionally followed by a colon and the
+//number of breaches it was seen in, as served by the Pwned Passwords range API.
+type Dir string
+
+//Open returns the directory at path after checking that it holds at least one file named by a hash prefix. A wrong path,
+//or a download in another layout such as a single file of all hashes, would otherwise let every password through.
+func Open(path string) (Dir, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("pwned: %v", err)
+	}
+	defer f.Close()
+	for {
+		es, err := f.ReadDir(1000)
+		for _, e := range es {
+			if isPrefix(strings.TrimSuffix(e.Name(), ".txt")) && e.Type().IsRegular() {
+				return Dir(path), nil
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("pwned: %s has no files named by the first 5 hex digits of SHA-1 hashes", path)
+		} else if err != nil {
+			return "", fmt.Errorf("pwned: %v", err)
+		}
+	}
+}
+
+//isPrefix returns true if s is 5 uppercase hex digits, as Breached looks for
+func isPrefix(s string) bool {
+	if len(s) != 5 {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+//Breached returns true if the SHA-1 hash of password is in the directory
+func (d Dir) Breached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	h := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := h[:5], h[5:]
+	f, err := os.Open(filepath.Join(string(d), prefix+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		f, err = os.Open(filepath.Join(string(d), prefix))
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		//No breached password has the prefix, unless the directory itself is gone
+		if _, err := os.Stat(string(d)); err != nil {
+			return false, err
+		}
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			line = line[:i]
+		}
+		if strings.EqualFold(strings.TrimSpace(line), suffix) {
+			return true, nil
+		}
+	}
+	return false, sc.Err()
+}
@@ -0,0 +1,76 @@
+package pwned
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//The SHA-1 hash of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+const (
+	passwordPrefix = "5BAA6"
+	passwordSuffix = "1E4C9B93F3F0682250B6CF8331B7EE68FD8"
+)
+
+func TestBreached(t *testing.T) {
+	for _, c := range []struct {
+		name, file, content string
+		breached            bool
+	}{
+		{"range API format", passwordPrefix + ".txt", "0018A45C4D1DEF81644B54AB7F969B88D65:1\n" + passwordSuffix + ":9659365\n", true},
+		{"without extension", passwordPrefix, passwordSuffix + "\n", true},
+		{"lowercase", passwordPrefix + ".txt", "1e4c9b93f3f0682250b6cf8331b7ee68fd8:3\n", true},
+		{"CRLF line endings", passwordPrefix + ".txt", "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n" + passwordSuffix + ":2\r\n", true},
+		{"other hashes of the prefix", passwordPrefix + ".txt", "0018A45C4D1DEF81644B54AB7F969B88D65:1\n", false},
+		{"no file for the prefix", "00000.txt", passwordSuffix + "\n", false},
+	} {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, c.file), []byte(c.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		b, err := Dir(dir).Breached("password")
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		} else if b != c.breached {
+			t.Errorf("%s: got %v, want %v", c.name, b, c.breached)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Open(filepath.Join(dir, "missing")); err == nil {
+		t.Error("missing directory: got no error")
+	}
+	//A download of all hashes in one file has none of the prefix files
+	if err := os.WriteFile(filepath.Join(dir, "pwned-passwords-sha1-ordered-by-hash-v8.txt"), []byte(passwordPrefix+passwordSuffix+":1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "ABCDE"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir); err == nil {
+		t.Error("directory without prefix files: got no error")
+	}
+	//Breached looks for uppercase names
+	if err := os.WriteFile(filepath.Join(dir, "5baa6.txt"), []byte(passwordSuffix+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir); err == nil {
+		t.Error("directory with lowercase prefix files: got no error")
+	}
+	if err := os.WriteFile(filepath.Join(dir, passwordPrefix+".txt"), []byte(passwordSuffix+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	//Passwords must not pass because the directory went away after the server started
+	if _, err := d.Breached("correct horse battery"); err == nil {
+		t.Error("removed directory: got no error")
+	}
+}
@@ -0,0 +1,106 @@
+//Package reaper deletes expired sessions in the background. Without it sessions are only deleted when their cookie is
+//presented after they expired, and the sessions of users who never return are kept forever. It also deletes the accounts
+//whose grace period after the user asked for their deletion is over.
+package reaper
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+//Metrics of all reapers, published by expvar as session_reaper
+var (
+	metrics = expvar.NewMap("session_reaper")
+	runs    = new(expvar.Int)
+	purged  = new(expvar.Int)
+	errs    = new(expvar.Int)
+	lastRun = new(expvar.String)
+	deleted = new(expvar.Int)
+)
+
+func init() {
+	metrics.Set("runs", runs)
+	metrics.Set("purged", purged)
+	metrics.Set("errors", errs)
+	metrics.Set("last_run", lastRun)
+	metrics.Set("deleted_users", deleted)
+}
+
+//Reaper deletes the sessions idle for longer than IdleTimeout every Interval. If it has the services, it also deletes
+//the expired "remember me" and one time tokens, the failed logins older than LoginAttemptsFor and the users scheduled for deletion.
+type Reaper struct {
+	UserService            finisafricae.UserService
+	SessionService         finisafricae.SessionService
+	PersistentTokenService finisafricae.PersistentTokenService
+	OneTimeTokenService    finisafricae.OneTimeTokenService
+	LoginAttemptService    finisafricae.LoginAttemptService
+	LoginAttemptsFor       time.Duration
+	Interval               time.Duration
+	IdleTimeout            time.Duration
+}
+
+//Run reaps once, then every Interval until ctx is done. Errors are logged and counted, and the reaper tries again next time.
+func (r *Reaper) Run(ctx context.Context) {
+	t := time.NewTicker(r.Interval)
+	defer t.Stop()
+	for {
+		n, err := r.Reap()
+		if err != nil {
+			log.Printf("reaping: %v", err)
+		}
+		if n > 0 {
+			log.Printf("reaped %d expired sessions", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+//Reap deletes the expired sessions and tokens and the accounts due for deletion once, and returns how many sessions were deleted.
+//Sessions past their absolute timeout but still in use are ended by the server on their next request. Each kind is deleted
+//whether or not deleting the others fails, and the errors are returned together.
+func (r *Reaper) Reap() (int64, error) {
+	now := time.Now()
+	runs.Add(1)
+	lastRun.Set(now.UTC().Format(time.RFC3339))
+	var failed []error
+	check := func(what string, err error) {
+		if err != nil {
+			errs.Add(1)
+			failed = append(failed, fmt.Errorf("%s: %w", what, err))
+		}
+	}
+	n, err := r.SessionService.DeleteExpired(now.Add(-r.IdleTimeout))
+	check("sessions", err)
+	purged.Add(n)
+	if r.PersistentTokenService != nil {
+		_, err := r.PersistentTokenService.DeleteExpired(now)
+		check("remember me tokens", err)
+	}
+	if r.OneTimeTokenService != nil {
+		_, err := r.OneTimeTokenService.DeleteExpired(now)
+		check("one time tokens", err)
+	}
+	if r.LoginAttemptService != nil {
+		_, err := r.LoginAttemptService.DeleteExpired(now.Add(-r.LoginAttemptsFor))
+		check("failed logins", err)
+	}
+	if r.UserService != nil {
+		d, err := r.UserService.DeleteScheduled(now)
+		check("accounts due for deletion", err)
+		deleted.Add(d)
+		if d > 0 {
+			log.Printf("deleted %d accounts at the end of their grace period", d)
+		}
+	}
+	return n, errors.Join(failed...)
+}
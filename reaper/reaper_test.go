@@ -0,0 +1,61 @@
+package reaper
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/madskrogh/finisafricae"
+)
+
+var errDown = errors.New("database is down")
+
+type failingSessions struct{ finisafricae.SessionService }
+
+func (failingSessions) DeleteExpired(before time.Time) (int64, error) { return 0, errDown }
+
+type failingTokens struct {
+	finisafricae.OneTimeTokenService
+}
+
+func (failingTokens) DeleteExpired(before time.Time) (int64, error) { return 0, errDown }
+
+type countingSessions struct{ finisafricae.SessionService }
+
+func (countingSessions) DeleteExpired(before time.Time) (int64, error) { return 3, nil }
+
+//scheduledUsers is a UserService recording when scheduled deletions were asked for
+type scheduledUsers struct {
+	finisafricae.UserService
+	calls int
+}
+
+func (s *scheduledUsers) DeleteScheduled(now time.Time) (int64, error) {
+	s.calls++
+	return 1, nil
+}
+
+func TestReapAfterFailure(t *testing.T) {
+	for name, r := range map[string]*Reaper{
+		"sessions fail":        {SessionService: failingSessions{}},
+		"one time tokens fail": {SessionService: countingSessions{}, OneTimeTokenService: failingTokens{}},
+	} {
+		us := &scheduledUsers{}
+		r.UserService = us
+		_, err := r.Reap()
+		if !errors.Is(err, errDown) {
+			t.Errorf("%s: got %v, want the error of the failed step", name, err)
+		}
+		if us.calls != 1 {
+			t.Errorf("%s: accounts due for deletion were deleted %d times, want once", name, us.calls)
+		}
+	}
+}
+
+func TestReap(t *testing.T) {
+	us := &scheduledUsers{}
+	n, err := (&Reaper{SessionService: countingSessions{}, UserService: us}).Reap()
+	if err != nil || n != 3 || us.calls != 1 {
+		t.Errorf("got %d sessions, %v and %d deletions", n, err, us.calls)
+	}
+}
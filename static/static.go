@@ -0,0 +1,9 @@
+//Package static embeds the stylesheets, scripts and images of the user interface
+package static
+
+import "embed"
+
+//FS holds the static files, served under /static/
+//
+//go:embed css
+var FS embed.FS
@@ -0,0 +1,9 @@
+//Package templates embeds the html templates of the user interface
+package templates
+
+import "embed"
+
+//FS holds the *.gohtml templates
+//
+//go:embed *.gohtml
+var FS embed.FS
@@ -0,0 +1,101 @@
+//Package totp implements the time-based one-time passwords of RFC 6238, as generated by authenticator apps
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//Parameters of the passwords. They are the defaults of RFC 6238, the only ones all authenticator apps support.
+const (
+	Period = 30 * time.Second
+	Digits = 6
+)
+
+//SecretSize is the size in bytes of secrets, the 160 bits RFC 4226 recommends. Shorter secrets are refused, as codes of a
+//short or empty secret can be guessed.
+const SecretSize = 20
+
+//skew is how many periods a code may be early or late, allowing for clocks that are slightly off
+const skew = 1
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+//NewSecret returns a random 160 bit secret, base32 encoded as authenticator apps expect it
+func NewSecret() (string, error) {
+	b := make([]byte, SecretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+//Step returns the time step of t
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+//Code returns the password of the time step for secret
+func Code(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("totp: invalid secret: %v", err)
+	}
+	if len(key) < SecretSize {
+		return "", fmt.Errorf("totp: secret of %d bytes is shorter than %d", len(key), SecretSize)
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	//Dynamic truncation of RFC 4226
+	off := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[off:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, v%uint32(math.Pow10(Digits))), nil
+}
+
+//Validate returns the time step of code if it is a password for secret at t, allowing for clock skew.
+//Steps up to and including after are rejected, so a code can't be used twice when the step of the last accepted code is passed.
+func Validate(secret, code string, t time.Time, after int64) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != Digits {
+		return 0, false
+	}
+	now := Step(t)
+	for step := now - skew; step <= now+skew; step++ {
+		if step <= after {
+			continue
+		}
+		c, err := Code(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(c), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+//URI returns the otpauth URI that authenticator apps read from QR codes, for the account of the issuer
+func URI(issuer, account, secret string) string {
+	v := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(Digits)},
+		"period":    {fmt.Sprint(int(Period / time.Second))},
+	}
+	//Some apps show a + in the issuer literally, so spaces are escaped as %20
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + strings.ReplaceAll(v.Encode(), "+", "%20")
+}
@@ -0,0 +1,98 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+//rfcSecret is the SHA-1 key of the test vectors of RFC 6238, "12345678901234567890", base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238(t *testing.T) {
+	//The RFC lists 8 digit codes, of which 6 digit codes are the last 6 digits
+	for unix, want := range map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	} {
+		got, err := Code(rfcSecret, Step(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want[2:] {
+			t.Errorf("code at %d is %s, want %s", unix, got, want[2:])
+		}
+	}
+}
+
+func TestCodeSecret(t *testing.T) {
+	lower, err := Code(strings.ToLower(rfcSecret), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upper, _ := Code(rfcSecret, 1); lower != upper {
+		t.Errorf("a lowercase secret gives %s, want %s", lower, upper)
+	}
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("an invalid secret gave a code")
+	}
+	//Codes of an empty key can be computed by anyone
+	for _, short := range []string{"", "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOI"} {
+		if _, err := Code(short, 1); err == nil {
+			t.Errorf("the short secret %q gave a code", short)
+		}
+		if _, ok := Validate(short, "000000", time.Now(), 0); ok {
+			t.Errorf("the short secret %q validated a code", short)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	code := func(step int64) string {
+		c, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	for _, c := range []struct {
+		name  string
+		code  string
+		after int64
+		ok    bool
+	}{
+		{"current", code(step), 0, true},
+		{"with spaces", " " + code(step) + " ", 0, true},
+		{"previous period", code(step - 1), 0, true},
+		{"next period", code(step + 1), 0, true},
+		{"too old", code(step - 2), 0, false},
+		{"too new", code(step + 2), 0, false},
+		{"reused", code(step), step, false},
+		{"previous period after the current one was used", code(step - 1), step, false},
+		{"next period after the current one was used", code(step + 1), step, true},
+		{"short", code(step)[1:], 0, false},
+		{"wrong", "000000", 0, false},
+	} {
+		got, ok := Validate(rfcSecret, c.code, now, c.after)
+		if ok != c.ok {
+			t.Errorf("%s: got %v, want %v", c.name, ok, c.ok)
+		}
+		if ok && (got < step-1 || got > step+1 || got <= c.after) {
+			t.Errorf("%s: got step %d", c.name, got)
+		}
+	}
+}
+
+func TestURI(t *testing.T) {
+	got := URI("finis Africae", "reader@example.com", rfcSecret)
+	want := "otpauth://totp/finis%20Africae:reader@example.com?algorithm=SHA1&digits=6&issuer=finis%20Africae&period=30&secret=" + rfcSecret
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}